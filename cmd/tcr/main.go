@@ -1,17 +1,106 @@
 package main
 
 import (
+	"flag"
 	"github.com/jaedle/test-and-commit-or-revert/internal"
 	"os"
+	"time"
 )
 
+// version is set on release builds.
+var version = "dev"
+
 func main() {
-	switch internal.New().Run() {
+	message := flag.String("m", "", "commit message to use instead of the configured template")
+	scope := flag.String("scope", "", "directory the cycle is limited to, relative to the root of the worktree")
+	flag.Parse()
+
+	var result internal.Result
+	switch flag.Arg(0) {
+	case "timer":
+		result = timer(flag.Args()[1:])
+	case "mob":
+		result = mob(flag.Args()[1:])
+	case "publish":
+		result = publish(flag.Args()[1:])
+	case "log":
+		result = log(flag.Args()[1:])
+	case "stats":
+		result = internal.New(internal.Options{}).Stats()
+	case "status":
+		result = internal.New(internal.Options{}).Status()
+	default:
+		result = internal.New(internal.Options{Message: *message, Version: version, Scope: *scope}).Run()
+	}
+
+	switch result {
 	case internal.Success:
 		os.Exit(0)
 	case internal.Failure:
 		os.Exit(1)
 	case internal.Error:
 		os.Exit(1)
+	case internal.Blocked:
+		os.Exit(2)
+	case internal.Busy:
+		os.Exit(3)
+	case internal.Unpushed:
+		os.Exit(4)
+	case internal.Rejected:
+		os.Exit(5)
+	case internal.Interrupted:
+		os.Exit(130)
+	}
+}
+
+func timer(args []string) internal.Result {
+	flags := flag.NewFlagSet("timer", flag.ExitOnError)
+	minutes := flags.Float64("minutes", 5, "minutes until uncommitted changes are reverted")
+	_ = flags.Parse(args)
+
+	return internal.New(internal.Options{}).Timer(minutesToDuration(*minutes))
+}
+
+func mob(args []string) internal.Result {
+	tcr := internal.New(internal.Options{})
+	if len(args) == 0 {
+		return tcr.MobStatus()
 	}
+
+	switch args[0] {
+	case "start":
+		flags := flag.NewFlagSet("mob start", flag.ExitOnError)
+		minutes := flags.Float64("minutes", 10, "minutes until the driver rotates")
+		_ = flags.Parse(args[1:])
+		return tcr.MobStart(flags.Args(), minutesToDuration(*minutes))
+	case "stop":
+		return tcr.MobStop()
+	case "status":
+		return tcr.MobStatus()
+	default:
+		_, _ = os.Stderr.WriteString("usage: tcr mob [start [--minutes n] members...|stop|status]\n")
+		return internal.Error
+	}
+}
+
+func publish(args []string) internal.Result {
+	flags := flag.NewFlagSet("publish", flag.ExitOnError)
+	squash := flags.Bool("squash", false, "squash the shadow commits into a single commit")
+	message := flags.String("m", "", "commit message of the squashed commit")
+	_ = flags.Parse(args)
+
+	return internal.New(internal.Options{Message: *message}).Publish(*squash)
+}
+
+func log(args []string) internal.Result {
+	flags := flag.NewFlagSet("log", flag.ExitOnError)
+	limit := flags.Int("n", 10, "number of commits to show, 0 shows all")
+	trailers := flags.Bool("trailers", false, "show the cycles recorded in the commit trailers as table")
+	_ = flags.Parse(args)
+
+	return internal.New(internal.Options{}).Log(*limit, *trailers)
+}
+
+func minutesToDuration(minutes float64) time.Duration {
+	return time.Duration(minutes * float64(time.Minute))
 }
@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const backupsDir = "backups"
+
+// backup copies every changed or untracked file of the worktree into the state
+// directory, so that the changes can be recovered after a revert.
+func (t *Tcr) backup() (string, error) {
+	wt, err := t.worktree()
+	if err != nil {
+		return "", err
+	}
+
+	files, err := t.changedFiles()
+	if err != nil {
+		return "", err
+	}
+
+	return t.backupFiles(wt.Filesystem.Root(), files)
+}
+
+// backupEdits copies the files changed while the tests were running into the
+// state directory.
+func (t *Tcr) backupEdits() (string, error) {
+	wt, err := t.worktree()
+	if err != nil {
+		return "", err
+	}
+
+	return t.backupFiles(wt.Filesystem.Root(), t.edited)
+}
+
+// backupFiles copies the files into a new backup directory and returns its
+// location.
+func (t *Tcr) backupFiles(root string, files []string) (string, error) {
+	dir, err := t.stateDir()
+	if err != nil {
+		return "", err
+	}
+
+	target := filepath.Join(dir, backupsDir, time.Now().Format("20060102-150405.000"))
+	for _, name := range files {
+		if err := copyFile(filepath.Join(root, name), filepath.Join(target, name)); err != nil {
+			return "", err
+		}
+	}
+
+	return target, nil
+}
+
+func copyFile(from string, to string) error {
+	info, err := os.Lstat(from)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(from)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, to)
+	} else if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	content, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(to, content, info.Mode().Perm())
+}
@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5/plumbing"
+	"path"
+	"time"
+)
+
+const tcrBranchPrefix = "tcr/"
+
+// guardBranch returns why tcr refuses to commit onto the checked out branch, or
+// an empty string if committing is allowed. Shadow commits never touch the
+// checked out branch and are always allowed.
+func (t *Tcr) guardBranch() (string, error) {
+	if t.config.Commit.Shadow {
+		return "", nil
+	}
+
+	if t.detached() {
+		if t.config.Branches.Detached == detachedRefuse {
+			return "HEAD is detached, check out a branch", nil
+		}
+		return "", nil
+	}
+
+	if protected, err := t.protected(); err != nil || !protected {
+		return "", err
+	}
+
+	if t.config.Branches.OnProtected == protectedBranch {
+		return "", nil
+	}
+
+	return fmt.Sprintf("branch %s is protected, switch to another branch or set branches.onProtected to %q", t.branch(), protectedBranch), nil
+}
+
+func (t *Tcr) detached() bool {
+	head, err := t.repo.Storer.Reference(plumbing.HEAD)
+	return err == nil && head.Type() == plumbing.HashReference
+}
+
+func (t *Tcr) protected() (bool, error) {
+	branch := t.branch()
+	if branch == "" {
+		return false, nil
+	}
+
+	for _, pattern := range t.config.Branches.Protected {
+		if match, err := path.Match(pattern, branch); err != nil {
+			return false, fmt.Errorf("invalid protected branch pattern %q: %w", pattern, err)
+		} else if match {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// leaveProtectedBranch checks out a new tcr branch at HEAD if the checked out
+// branch is protected. The worktree and the index are kept as they are. The
+// returned function switches back to the protected branch and removes the new
+// branch, in case the commit fails.
+func (t *Tcr) leaveProtectedBranch() (func() error, error) {
+	if protected, err := t.protected(); err != nil || !protected {
+		return func() error { return nil }, err
+	}
+
+	protected, err := t.repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := t.newTcrBranch()
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := t.repo.Head()
+	if err == nil {
+		if err := t.repo.Storer.SetReference(plumbing.NewHashReference(name, head.Hash())); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, err
+	}
+
+	if err := t.repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, name)); err != nil {
+		return nil, err
+	}
+
+	t.logger.Warn().Msgf("branch %s is protected, switched to new branch %s", protected.Target().Short(), name.Short())
+	return func() error {
+		if err := t.repo.Storer.SetReference(protected); err != nil {
+			return err
+		}
+
+		if err := t.repo.Storer.RemoveReference(name); err != nil {
+			return err
+		}
+
+		t.logger.Info().Msgf("switched back to branch %s", protected.Target().Short())
+		return nil
+	}, nil
+}
+
+// newTcrBranch returns a name for a new tcr branch that does not exist yet.
+// Branches created within the same second get a numbered suffix.
+func (t *Tcr) newTcrBranch() (plumbing.ReferenceName, error) {
+	base := tcrBranchPrefix + time.Now().Format("20060102-150405")
+	for i := 1; ; i++ {
+		name := plumbing.NewBranchReferenceName(base)
+		if i > 1 {
+			name = plumbing.NewBranchReferenceName(fmt.Sprintf("%s-%d", base, i))
+		}
+
+		if _, err := t.repo.Storer.Reference(name); errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return name, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+}
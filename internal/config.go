@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"fmt"
+	"time"
+)
+
+type config struct {
+	Test       string          `json:"test"`
+	Profile    string          `json:"profile"`
+	Isolate    bool            `json:"isolate"`
+	Timeout    timeoutConfig   `json:"timeout"`
+	VCS        string          `json:"vcs"`
+	Scope      string          `json:"scope"`
+	Submodules string          `json:"submodules"`
+	Commit     commitConfig    `json:"commit"`
+	Mob        mobConfig       `json:"mob"`
+	Revert     revertConfig    `json:"revert"`
+	Branches   branchesConfig  `json:"branches"`
+	Identity   identityConfig  `json:"identity"`
+	Lock       lockConfig      `json:"lock"`
+	Notes      notesConfig     `json:"notes"`
+	AutoPush   *autoPushConfig `json:"autoPush"`
+}
+
+type commitConfig struct {
+	Message            string              `json:"message"`
+	TicketPattern      string              `json:"ticketPattern"`
+	Conventional       *conventionalConfig `json:"conventional"`
+	Shadow             bool                `json:"shadow"`
+	Trailers           []string            `json:"trailers"`
+	TestsPassedPattern string              `json:"testsPassedPattern"`
+	Hooks              bool                `json:"hooks"`
+}
+
+type conventionalConfig struct {
+	Types  []string `json:"types"`
+	Scopes []string `json:"scopes"`
+}
+
+type mobConfig struct {
+	Members map[string]string `json:"members"`
+}
+
+const (
+	untrackedRemove = "remove"
+	untrackedKeep   = "keep"
+	untrackedBackup = "backup"
+)
+
+type revertConfig struct {
+	Untracked string `json:"untracked"`
+	Target    string `json:"target"`
+}
+
+const (
+	protectedRefuse = "refuse"
+	protectedBranch = "branch"
+)
+
+const (
+	detachedCommit = "commit"
+	detachedRefuse = "refuse"
+)
+
+type branchesConfig struct {
+	Protected   []string `json:"protected"`
+	OnProtected string   `json:"onProtected"`
+	Detached    string   `json:"detached"`
+}
+
+func (c config) validate() error {
+	switch c.VCS {
+	case "", vcsGoGit, vcsGit:
+	default:
+		return fmt.Errorf("vcs must be one of %s or %s", vcsGoGit, vcsGit)
+	}
+
+	switch c.Revert.Untracked {
+	case "", untrackedRemove, untrackedKeep, untrackedBackup:
+	default:
+		return fmt.Errorf("revert.untracked must be one of %s, %s or %s", untrackedRemove, untrackedKeep, untrackedBackup)
+	}
+
+	switch c.Branches.OnProtected {
+	case "", protectedRefuse, protectedBranch:
+	default:
+		return fmt.Errorf("branches.onProtected must be one of %s or %s", protectedRefuse, protectedBranch)
+	}
+
+	switch c.Revert.Target {
+	case "", revertHead:
+	case revertGreen:
+		if c.Commit.Shadow {
+			return fmt.Errorf("revert.target %s is not supported with commit.shadow", revertGreen)
+		}
+	default:
+		return fmt.Errorf("revert.target must be one of %s or %s", revertHead, revertGreen)
+	}
+
+	switch c.Branches.Detached {
+	case "", detachedCommit, detachedRefuse:
+	default:
+		return fmt.Errorf("branches.detached must be one of %s or %s", detachedCommit, detachedRefuse)
+	}
+
+	switch c.Submodules {
+	case "", submodulesIgnore, submodulesRefuse:
+	case submodulesRecursive:
+		if c.Commit.Shadow {
+			return fmt.Errorf("submodules %s is not supported with commit.shadow", submodulesRecursive)
+		}
+	default:
+		return fmt.Errorf("submodules must be one of %s, %s or %s", submodulesIgnore, submodulesRecursive, submodulesRefuse)
+	}
+
+	for _, name := range c.Commit.Trailers {
+		if _, ok := trailerKeys[name]; !ok {
+			return fmt.Errorf("commit.trailers contains unknown trailer %q", name)
+		}
+	}
+
+	if c.Timeout.After != "" {
+		if _, err := time.ParseDuration(c.Timeout.After); err != nil {
+			return fmt.Errorf("timeout.after must be a duration like 10m: %w", err)
+		}
+	}
+
+	if c.Timeout.Grace != "" {
+		if _, err := time.ParseDuration(c.Timeout.Grace); err != nil {
+			return fmt.Errorf("timeout.grace must be a duration like 5s: %w", err)
+		}
+	}
+
+	switch c.Timeout.Outcome {
+	case "", timeoutFailure, timeoutError:
+	default:
+		return fmt.Errorf("timeout.outcome must be one of %s or %s", timeoutFailure, timeoutError)
+	}
+
+	if c.Lock.Wait != "" {
+		if _, err := time.ParseDuration(c.Lock.Wait); err != nil {
+			return fmt.Errorf("lock.wait must be a duration like 10s: %w", err)
+		}
+	}
+
+	if c.AutoPush != nil && c.Commit.Shadow {
+		return fmt.Errorf("autoPush is not supported with commit.shadow")
+	}
+
+	return nil
+}
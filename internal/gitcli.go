@@ -0,0 +1,216 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"io"
+	"maps"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// gitCLI runs the system git, so that every feature of the installed git like
+// sparse checkouts, fsmonitor or clean and smudge filters is honoured.
+type gitCLI struct {
+	root string
+}
+
+func newGitCLI(root string) (gitCLI, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return gitCLI{}, fmt.Errorf("vcs %s requires git to be installed: %w", vcsGit, err)
+	}
+
+	return gitCLI{root: root}, nil
+}
+
+func (g gitCLI) run(stdin io.Reader, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", g.root, "--literal-pathspecs"}, args...)...)
+	cmd.Stdin = stdin
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
+
+func (g gitCLI) status() (map[string]bool, error) {
+	out, err := g.run(nil, "status", "--porcelain", "-z", "--untracked-files=all", "--no-renames")
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]bool{}
+	for _, entry := range strings.Split(out, "\x00") {
+		if len(entry) > 3 {
+			changes[entry[3:]] = entry[:2] == "??"
+		}
+	}
+
+	return changes, nil
+}
+
+func (g gitCLI) stage(files []string) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	_, err := g.run(strings.NewReader(strings.Join(files, "\x00")),
+		"add", "--all", "--pathspec-from-file=-", "--pathspec-file-nul")
+	return err
+}
+
+// commit writes the commit object itself instead of running git commit, so
+// that identity and signature are the same for every vcs.
+func (g gitCLI) commit(msg string, opts *git.CommitOptions) (plumbing.Hash, error) {
+	tree, err := g.run(nil, "write-tree")
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	head, err := g.head()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	parents := opts.Parents
+	if parents == nil && !head.IsZero() {
+		parents = []plumbing.Hash{head}
+	}
+
+	commit := &object.Commit{
+		Author:       *opts.Author,
+		Committer:    *opts.Committer,
+		Message:      msg,
+		TreeHash:     plumbing.NewHash(strings.TrimSpace(tree)),
+		ParentHashes: parents,
+	}
+
+	if err := signCommit(opts.Signer, commit); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	encoded := &plumbing.MemoryObject{}
+	if err := commit.Encode(encoded); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	r, err := encoded.Reader()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	out, err := g.run(r, "hash-object", "-t", "commit", "-w", "--stdin")
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	hash := plumbing.NewHash(strings.TrimSpace(out))
+	subject, _, _ := strings.Cut(msg, "\n")
+	// HEAD is only moved if nobody else has moved it in the meantime, the zero
+	// hash requires that there is no commit yet
+	if _, err := g.run(nil, "update-ref", "-m", "commit: "+subject, "HEAD", hash.String(), head.String()); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return hash, nil
+}
+
+// head returns the commit of HEAD, or the zero hash if there is no commit yet.
+func (g gitCLI) head() (plumbing.Hash, error) {
+	out, err := g.run(nil, "rev-parse", "-q", "--verify", "HEAD^{commit}")
+	if err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && exit.ExitCode() == 1 {
+			return plumbing.ZeroHash, nil
+		}
+		return plumbing.ZeroHash, err
+	}
+
+	return plumbing.NewHash(strings.TrimSpace(out)), nil
+}
+
+func (g gitCLI) restore(commit plumbing.Hash, files []string) error {
+	existing, err := g.existing(commit, files)
+	if err != nil {
+		return err
+	}
+
+	var restore []string
+	for _, name := range files {
+		if err := removeFile(g.root, name); err != nil {
+			return err
+		}
+		if existing[name] {
+			restore = append(restore, name)
+		}
+	}
+
+	if len(restore) == 0 {
+		return nil
+	}
+
+	_, err = g.run(strings.NewReader(strings.Join(restore, "\x00")),
+		"restore", "--source="+commit.String(), "--worktree", "--pathspec-from-file=-", "--pathspec-file-nul")
+	return err
+}
+
+// existing returns which of the files the commit contains.
+func (g gitCLI) existing(commit plumbing.Hash, files []string) (map[string]bool, error) {
+	result := map[string]bool{}
+	if commit.IsZero() || len(files) == 0 {
+		return result, nil
+	}
+
+	var in strings.Builder
+	for _, name := range files {
+		in.WriteString(commit.String() + ":" + name + "\n")
+	}
+
+	out, err := g.run(strings.NewReader(in.String()), "cat-file", "--batch-check=%(objecttype)")
+	if err != nil {
+		return nil, err
+	}
+
+	for i, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		if i < len(files) && !strings.HasSuffix(line, " missing") {
+			result[files[i]] = true
+		}
+	}
+
+	return result, nil
+}
+
+func (g gitCLI) resetIndex(files []string) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	_, err := g.run(strings.NewReader(strings.Join(files, "\x00")),
+		"reset", "-q", "--pathspec-from-file=-", "--pathspec-file-nul")
+	return err
+}
+
+func (g gitCLI) setIndex(states map[string]fileState) error {
+	if len(states) == 0 {
+		return nil
+	}
+
+	var in strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(states)) {
+		// mode 0 removes the file from the index
+		state := states[name]
+		fmt.Fprintf(&in, "%o %s\t%s\x00", state.mode, state.hash, name)
+	}
+
+	_, err := g.run(strings.NewReader(in.String()), "update-index", "-z", "--index-info")
+	return err
+}
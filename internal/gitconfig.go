@@ -0,0 +1,47 @@
+package internal
+
+import (
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"strings"
+)
+
+// gitConfig returns the value of an option the way git resolves it: the
+// repository configuration wins over the global and the system configuration.
+func (t *Tcr) gitConfig(section string, key string) (string, error) {
+	local, err := t.repo.Config()
+	if err != nil {
+		return "", err
+	}
+
+	global, err := gitconfig.LoadConfig(gitconfig.GlobalScope)
+	if err != nil {
+		return "", err
+	}
+
+	system, err := gitconfig.LoadConfig(gitconfig.SystemScope)
+	if err != nil {
+		return "", err
+	}
+
+	for _, c := range []*gitconfig.Config{local, global, system} {
+		if s := c.Raw.Section(section); s.HasOption(key) {
+			return s.Options.Get(key), nil
+		}
+	}
+
+	return "", nil
+}
+
+func (t *Tcr) gitConfigBool(section string, key string) (bool, error) {
+	value, err := t.gitConfig(section, key)
+	if err != nil {
+		return false, err
+	}
+
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
@@ -0,0 +1,221 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"io"
+	"strings"
+)
+
+const greenRef = plumbing.ReferenceName("refs/tcr/green")
+const origHead = plumbing.ReferenceName("ORIG_HEAD")
+
+const (
+	revertHead  = "head"
+	revertGreen = "green"
+)
+
+// markGreen records the commit as the last commit whose tree passed the tests.
+func (t *Tcr) markGreen(commit plumbing.Hash) error {
+	return t.repo.Storer.SetReference(plumbing.NewHashReference(greenRef, commit))
+}
+
+// lastGreen returns the last commit that passed the tests, or nil if there is
+// none yet.
+func (t *Tcr) lastGreen() (*object.Commit, error) {
+	ref, err := t.repo.Reference(greenRef, true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return t.repo.CommitObject(ref.Hash())
+}
+
+// aheadOfGreen returns the number of commits HEAD is ahead of the last green
+// commit. It is -1 if there is no green commit or HEAD does not descend from
+// it, i.e. because another branch is checked out.
+func (t *Tcr) aheadOfGreen(green *object.Commit) (int, error) {
+	head, err := t.repo.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) || green == nil {
+		return -1, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	commits, err := t.repo.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		return 0, err
+	}
+	defer commits.Close()
+
+	for ahead := 0; ; ahead++ {
+		c, err := commits.Next()
+		if errors.Is(err, io.EOF) {
+			return -1, nil
+		} else if err != nil {
+			return 0, err
+		} else if c.Hash == green.Hash {
+			return ahead, nil
+		}
+	}
+}
+
+// greenRevertCommit returns the last green commit if the worktree is reverted
+// to it, or the zero hash if it is reverted to HEAD.
+func (t *Tcr) greenRevertCommit() (plumbing.Hash, error) {
+	if t.config.Revert.Target != revertGreen {
+		return plumbing.ZeroHash, nil
+	}
+
+	green, err := t.lastGreen()
+	if err != nil || green == nil {
+		return plumbing.ZeroHash, err
+	}
+
+	ahead, err := t.aheadOfGreen(green)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	} else if ahead < 0 {
+		t.logger.Warn().Msg("HEAD does not descend from the last green commit, reverting to HEAD")
+		return plumbing.ZeroHash, nil
+	} else if ahead == 0 {
+		return plumbing.ZeroHash, nil
+	}
+
+	return green.Hash, nil
+}
+
+// resetHead moves HEAD back to the commit and returns the paths the commits
+// in between have changed. The previous HEAD is kept in ORIG_HEAD like git
+// reset does. Submodules are left at their commit.
+func (t *Tcr) resetHead(commit plumbing.Hash, subs []submodule) ([]string, error) {
+	head, err := t.repo.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	changed, err := t.changedBetween(head.Hash(), commit)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, name := range changed {
+		if !insideSubmodule(name, subs) {
+			files = append(files, name)
+		}
+	}
+
+	if err := t.repo.Storer.SetReference(plumbing.NewHashReference(origHead, head.Hash())); err != nil {
+		return nil, err
+	}
+
+	if err := t.repo.Storer.SetReference(plumbing.NewHashReference(head.Name(), commit)); err != nil {
+		return nil, err
+	}
+
+	t.logger.Warn().Msgf("reverted to the last green commit %s, the previous HEAD %s is kept in %s", commit.String()[:7], head.Hash().String()[:7], origHead)
+	return files, nil
+}
+
+// changedBetween returns the paths that differ between the trees of the two
+// commits.
+func (t *Tcr) changedBetween(from plumbing.Hash, to plumbing.Hash) ([]string, error) {
+	fromTree, err := t.treeOf(from)
+	if err != nil {
+		return nil, err
+	}
+
+	toTree, err := t.treeOf(to)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, c := range changes {
+		name := c.From.Name
+		if name == "" {
+			name = c.To.Name
+		}
+		files = append(files, name)
+	}
+
+	return files, nil
+}
+
+// Status shows the last green commit and warns if HEAD is ahead of it.
+func (t *Tcr) Status() Result {
+	if err := t.openRepository(); err != nil {
+		t.logger.Err(err).Msg("error on opening git repository")
+		return Error
+	}
+
+	if err := t.readConfig(); err != nil {
+		t.logger.Err(err).Msg("error on reading configuration")
+		return Error
+	}
+
+	if err := t.status(); err != nil {
+		t.logger.Err(err).Msg("error on reading the status")
+		return Error
+	}
+
+	return Success
+}
+
+func (t *Tcr) status() error {
+	if branch := t.branch(); branch != "" {
+		fmt.Printf("branch:      %s\n", branch)
+	}
+
+	head, err := t.repo.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		fmt.Println("head:        (no commits)")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	headCommit, err := t.repo.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("head:        %s\n", summary(headCommit))
+
+	green, err := t.lastGreen()
+	if err != nil {
+		return err
+	} else if green == nil {
+		fmt.Println("last green:  (none)")
+		return nil
+	}
+	fmt.Printf("last green:  %s\n", summary(green))
+
+	ahead, err := t.aheadOfGreen(green)
+	if err != nil {
+		return err
+	}
+
+	if ahead < 0 {
+		t.logger.Warn().Msg("HEAD does not descend from the last green commit")
+	} else if ahead > 0 {
+		t.logger.Warn().Msgf("HEAD is %d commit(s) ahead of the last green commit, they have not passed the tests with tcr", ahead)
+	}
+
+	return nil
+}
+
+func summary(c *object.Commit) string {
+	subject, _, _ := strings.Cut(c.Message, "\n")
+	return c.Hash.String()[:7] + " " + subject
+}
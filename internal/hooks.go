@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	hookPreCommit        = "pre-commit"
+	hookPrepareCommitMsg = "prepare-commit-msg"
+	hookCommitMsg        = "commit-msg"
+	hookPostCommit       = "post-commit"
+)
+
+const commitMessageFile = "COMMIT_EDITMSG"
+
+// hookRejection is returned if a hook rejects a commit.
+type hookRejection struct {
+	hook   string
+	reason string
+}
+
+func (r *hookRejection) Error() string {
+	return fmt.Sprintf("%s hook rejected the commit: %s", r.hook, r.reason)
+}
+
+// runCommitHooks runs the hooks git runs before a commit and returns the
+// message, which the hooks may have changed.
+func (t *Tcr) runCommitHooks(msg string) (string, error) {
+	if err := t.runHook(hookPreCommit); err != nil {
+		return "", err
+	}
+
+	dir, err := t.gitDir()
+	if err != nil {
+		return "", err
+	}
+
+	file := filepath.Join(dir, commitMessageFile)
+	if err := os.WriteFile(file, []byte(msg+"\n"), 0o644); err != nil {
+		return "", err
+	}
+
+	if err := t.runHook(hookPrepareCommitMsg, file, "message"); err != nil {
+		return "", err
+	}
+
+	if err := t.runHook(hookCommitMsg, file); err != nil {
+		return "", err
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	} else if string(content) == msg+"\n" {
+		return msg, nil
+	}
+
+	edited := cleanupMessage(string(content))
+	if edited == "" {
+		return "", &hookRejection{hook: hookCommitMsg, reason: "the commit message is empty"}
+	}
+
+	return edited, nil
+}
+
+// runHook runs the hook with the arguments and the environment git uses, a hook
+// that does not exist or is not executable is skipped.
+func (t *Tcr) runHook(name string, args ...string) error {
+	path, err := t.hookPath(name)
+	if err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	} else if info.IsDir() || info.Mode().Perm()&0o111 == 0 {
+		t.logger.Debug().Msgf("skipping %s hook, it is not executable", name)
+		return nil
+	}
+
+	wt, err := t.worktree()
+	if err != nil {
+		return err
+	}
+
+	dir, err := t.gitDir()
+	if err != nil {
+		return err
+	}
+
+	t.logger.Trace().Msgf("running %s hook", name)
+	cmd := exec.Command(path, args...)
+	cmd.Dir = wt.Filesystem.Root()
+	cmd.Env = append(os.Environ(), "GIT_INDEX_FILE="+filepath.Join(dir, "index"), "GIT_EDITOR=:")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stdout
+
+	var exit *exec.ExitError
+	if err := cmd.Run(); errors.As(err, &exit) {
+		return &hookRejection{hook: name, reason: err.Error()}
+	} else if err != nil {
+		return fmt.Errorf("%s hook: %w", name, err)
+	}
+
+	return nil
+}
+
+// hookPath returns the path of the hook in core.hooksPath, or in the hooks
+// directory of the common git directory.
+func (t *Tcr) hookPath(name string) (string, error) {
+	dir, err := t.gitConfig("core", "hooksPath")
+	if err != nil {
+		return "", err
+	}
+
+	if dir == "" {
+		common, err := t.commonDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(common, "hooks", name), nil
+	}
+
+	if strings.HasPrefix(dir, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, dir[2:])
+	} else if !filepath.IsAbs(dir) {
+		wt, err := t.worktree()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(wt.Filesystem.Root(), dir)
+	}
+
+	return filepath.Join(dir, name), nil
+}
+
+// cleanupMessage removes trailing whitespace, repeated blank lines and
+// surrounding blank lines, like git does for messages given on the command
+// line.
+func cleanupMessage(msg string) string {
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(msg, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	roleAuthor    = "author"
+	roleCommitter = "committer"
+)
+
+type identityConfig struct {
+	Author    string `json:"author"`
+	Committer string `json:"committer"`
+}
+
+// loadIdentity resolves author and committer before any work is done, so that
+// a green run never fails on commit because of a missing identity.
+func (t *Tcr) loadIdentity() error {
+	author, err := t.resolveIdentity(roleAuthor, t.config.Identity.Author)
+	if err != nil {
+		return err
+	}
+
+	committer, err := t.resolveIdentity(roleCommitter, t.config.Identity.Committer)
+	if err != nil {
+		return err
+	}
+
+	t.author = author
+	t.committer = committer
+	return nil
+}
+
+// resolveIdentity resolves an identity the way git does: environment variables
+// win over author.* and committer.* options, which win over user.*. Options
+// of the repository configuration win over the global configuration. The
+// identity configured for tcr wins over everything.
+func (t *Tcr) resolveIdentity(role string, override string) (object.Signature, error) {
+	if override != "" {
+		identity, err := parseIdentity(override, time.Time{})
+		if err != nil {
+			return object.Signature{}, fmt.Errorf("identity.%s: %w", role, err)
+		}
+		return *identity, nil
+	}
+
+	name, err := t.firstOf(os.Getenv("GIT_"+strings.ToUpper(role)+"_NAME"), role, "name")
+	if err != nil {
+		return object.Signature{}, err
+	}
+
+	email, err := t.firstOf(os.Getenv("GIT_"+strings.ToUpper(role)+"_EMAIL"), role, "email")
+	if err != nil {
+		return object.Signature{}, err
+	} else if email == "" {
+		email = os.Getenv("EMAIL")
+	}
+
+	if name == "" || email == "" {
+		return object.Signature{}, fmt.Errorf("no %s identity found, configure user.name and user.email", role)
+	}
+
+	return object.Signature{Name: name, Email: email}, nil
+}
+
+func (t *Tcr) firstOf(env string, role string, key string) (string, error) {
+	if env != "" {
+		return env, nil
+	}
+
+	if value, err := t.gitConfig(role, key); err != nil || value != "" {
+		return value, err
+	}
+
+	return t.gitConfig("user", key)
+}
+
+func (t *Tcr) signatures(when time.Time) (*object.Signature, *object.Signature) {
+	author, committer := t.author, t.committer
+	author.When, committer.When = when, when
+	return &author, &committer
+}
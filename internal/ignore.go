@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// worktree opens the worktree with all exclude patterns git would apply.
+// go-git reads .gitignore on its own, .git/info/exclude and core.excludesFile
+// of the repository, global and system configuration are added here.
+func (t *Tcr) worktree() (*git.Worktree, error) {
+	wt, err := t.repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+
+	root := osfs.New("/")
+	system, err := gitignore.LoadSystemPatterns(root)
+	if err != nil {
+		return nil, err
+	}
+
+	global, err := gitignore.LoadGlobalPatterns(root)
+	if err != nil {
+		return nil, err
+	}
+
+	local, err := t.localExcludes(wt.Filesystem.Root())
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := t.commonDir()
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := readExcludesFile(filepath.Join(dir, "info", "exclude"))
+	if err != nil {
+		return nil, err
+	}
+
+	wt.Excludes = append(wt.Excludes, system...)
+	wt.Excludes = append(wt.Excludes, global...)
+	wt.Excludes = append(wt.Excludes, local...)
+	wt.Excludes = append(wt.Excludes, info...)
+	return wt, nil
+}
+
+// localExcludes reads the patterns of core.excludesFile of the repository
+// configuration.
+func (t *Tcr) localExcludes(root string) ([]gitignore.Pattern, error) {
+	cfg, err := t.repo.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	path := cfg.Raw.Section("core").Options.Get("excludesfile")
+	if path == "" {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, path[2:])
+	} else if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+
+	return readExcludesFile(path)
+}
+
+func readExcludesFile(path string) ([]gitignore.Pattern, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	var patterns []gitignore.Pattern
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, gitignore.ParsePattern(line, nil))
+		}
+	}
+
+	return patterns, scanner.Err()
+}
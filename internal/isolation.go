@@ -0,0 +1,198 @@
+package internal
+
+import (
+	"errors"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const checkoutFile = "checkout"
+
+// isolatedCheckout writes the files of the last commit together with the
+// changes of the snapshot into a temporary directory, which is exactly the
+// tree that is committed if the tests pass. Ignored and untracked files are
+// left out. The returned function removes the directory, a directory left
+// behind by a crashed cycle is removed by the next one.
+func (t *Tcr) isolatedCheckout() (string, func(), error) {
+	if err := t.removeCheckout(); err != nil {
+		return "", nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "tcr-checkout-")
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := t.writeState(checkoutFile, dir); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", nil, err
+	}
+
+	cleanup := func() {
+		if err := t.removeCheckout(); err != nil {
+			t.logger.Warn().Err(err).Msgf("error on removing the isolated checkout %s", dir)
+		}
+	}
+
+	if err := t.writeCheckout(dir); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	return dir, cleanup, nil
+}
+
+func (t *Tcr) writeCheckout(dir string) error {
+	wt, err := t.worktree()
+	if err != nil {
+		return err
+	}
+
+	head, err := t.headHash()
+	if err != nil {
+		return err
+	}
+
+	tree, err := t.treeOf(head)
+	if err != nil {
+		return err
+	}
+
+	if err := writeTree(t.repo, wt.Filesystem.Root(), tree, dir); err != nil {
+		return err
+	}
+
+	// removals go first, a file may have been replaced by a directory with
+	// files of the snapshot inside
+	paths := t.snapshot.paths()
+	for _, name := range paths {
+		if err := os.RemoveAll(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range paths {
+		state, path := t.snapshot.files[name], filepath.Join(dir, name)
+		if state.hash.IsZero() {
+			continue
+		} else if state.mode == filemode.Submodule {
+			err = writeSubmodule(filepath.Join(wt.Filesystem.Root(), name), state.hash, path)
+		} else {
+			err = writeBlob(t.repo, state.hash, state.mode, path)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeCheckout removes the isolated checkout recorded in the state.
+func (t *Tcr) removeCheckout() error {
+	dir, err := t.readState(checkoutFile)
+	if err != nil || dir == "" {
+		return err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+
+	return t.removeState(checkoutFile)
+}
+
+// writeTree writes the files of the tree into the directory. Submodules are
+// written with the files of their recorded commit, or as an empty directory if
+// they are not initialized in the worktree at root.
+func writeTree(repo *git.Repository, root string, tree *object.Tree, dir string) error {
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+
+	for {
+		name, entry, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		path := filepath.Join(dir, name)
+		switch entry.Mode {
+		case filemode.Dir:
+			err = os.MkdirAll(path, 0o755)
+		case filemode.Submodule:
+			err = writeSubmodule(filepath.Join(root, name), entry.Hash, path)
+		default:
+			err = writeBlob(repo, entry.Hash, entry.Mode, path)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func writeSubmodule(worktree string, commit plumbing.Hash, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	sub, err := git.PlainOpenWithOptions(worktree, &git.PlainOpenOptions{EnableDotGitCommonDir: true})
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	c, err := sub.CommitObject(commit)
+	if err != nil {
+		return err
+	}
+
+	tree, err := c.Tree()
+	if err != nil {
+		return err
+	}
+
+	return writeTree(sub, worktree, tree, dir)
+}
+
+func writeBlob(repo *git.Repository, hash plumbing.Hash, mode filemode.FileMode, path string) error {
+	blob, err := repo.BlobObject(hash)
+	if err != nil {
+		return err
+	}
+
+	r, err := blob.Reader()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	if mode == filemode.Symlink {
+		return os.Symlink(string(content), path)
+	}
+
+	perm, err := mode.ToOSFileMode()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, content, perm.Perm())
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const lockFile = "lock"
+const lockPoll = 100 * time.Millisecond
+
+var errBusy = errors.New("another tcr cycle is running")
+
+type lockConfig struct {
+	Wait string `json:"wait"`
+}
+
+func (c lockConfig) wait() time.Duration {
+	d, _ := time.ParseDuration(c.Wait)
+	return d
+}
+
+// lock acquires the run lock of the worktree, so that no two cycles touch the
+// worktree at the same time. It waits up to the given duration for another
+// cycle to finish and fails with errBusy afterwards. The returned function
+// releases the lock.
+func (t *Tcr) lock(wait time.Duration) (func(), error) {
+	dir, err := t.stateDir()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, lockFile)
+	deadline := time.Now().Add(wait)
+	for {
+		if f, err := tryLock(path); err != nil {
+			return nil, err
+		} else if f != nil {
+			return func() { releaseLock(path, f) }, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, errBusy
+		}
+		time.Sleep(lockPoll)
+	}
+}
+
+// tryLock takes an advisory lock of the operating system on the lock file, it
+// returns nil if another process holds it. The operating system releases the
+// lock if a process crashes, a lock file left behind is simply taken over.
+func tryLock(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	if locked, err := lockFileHandle(f); err != nil || !locked {
+		return nil, errors.Join(err, f.Close())
+	}
+
+	// the holder before may have removed the file after this one opened it,
+	// the lock is only valid on the file at the path
+	if current, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, f.Close()
+	} else if err != nil {
+		return nil, errors.Join(err, f.Close())
+	} else if info, err := f.Stat(); err != nil || !os.SameFile(info, current) {
+		return nil, errors.Join(err, f.Close())
+	}
+
+	if err := f.Truncate(0); err != nil {
+		return nil, errors.Join(err, f.Close())
+	}
+
+	if _, err := f.WriteAt([]byte(lockOwner()), 0); err != nil {
+		return nil, errors.Join(err, f.Close())
+	}
+
+	return f, nil
+}
+
+// releaseLock removes the lock file while still holding the lock, so that no
+// other process takes a lock on a file that is about to disappear.
+func releaseLock(path string, f *os.File) {
+	_ = os.Remove(path)
+	_ = f.Close()
+}
+
+func lockOwner() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%d\n%s\n", os.Getpid(), host)
+}
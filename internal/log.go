@@ -0,0 +1,204 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const logDateFormat = "Mon Jan 2 15:04:05 2006 -0700"
+
+// Log shows the last commits together with their notes, like git log does.
+// With trailers, a table of the cycles parsed from the commit trailers is
+// shown instead.
+func (t *Tcr) Log(limit int, trailers bool) Result {
+	if err := t.openRepository(); err != nil {
+		t.logger.Err(err).Msg("error on opening git repository")
+		return Error
+	}
+
+	if err := t.readConfig(); err != nil {
+		t.logger.Err(err).Msg("error on reading configuration")
+		return Error
+	}
+
+	var err error
+	if trailers {
+		err = t.logTrailers(limit)
+	} else {
+		err = t.log(limit)
+	}
+
+	if err != nil {
+		t.logger.Err(err).Msg("error on reading the history")
+		return Error
+	}
+
+	return Success
+}
+
+// Stats summarizes the cycles recorded in the commit trailers of the history.
+func (t *Tcr) Stats() Result {
+	if err := t.openRepository(); err != nil {
+		t.logger.Err(err).Msg("error on opening git repository")
+		return Error
+	}
+
+	if err := t.readConfig(); err != nil {
+		t.logger.Err(err).Msg("error on reading configuration")
+		return Error
+	}
+
+	if err := t.stats(); err != nil {
+		t.logger.Err(err).Msg("error on reading the history")
+		return Error
+	}
+
+	return Success
+}
+
+// history calls f for the last commits, starting with the newest one. A
+// limit of zero visits all commits.
+func (t *Tcr) history(limit int, f func(c *object.Commit) error) error {
+	head, err := t.headHash()
+	if err != nil || head.IsZero() {
+		return err
+	}
+
+	commits, err := t.repo.Log(&git.LogOptions{From: head})
+	if err != nil {
+		return err
+	}
+	defer commits.Close()
+
+	for i := 0; limit <= 0 || i < limit; i++ {
+		c, err := commits.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := f(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *Tcr) log(limit int) error {
+	notes, err := t.notesCommit()
+	if err != nil {
+		return err
+	}
+
+	first := true
+	return t.history(limit, func(c *object.Commit) error {
+		note, err := t.readNote(notes, c.Hash)
+		if err != nil {
+			return err
+		}
+
+		if !first {
+			fmt.Println()
+		}
+		first = false
+		printCommit(c, note)
+		return nil
+	})
+}
+
+func (t *Tcr) logTrailers(limit int) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "commit\tcycle\tduration\ttests passed\tcommand\tprofile")
+
+	if err := t.history(limit, func(c *object.Commit) error {
+		if cycle, ok := parseCycle(c); ok {
+			_, _ = fmt.Fprintln(w, cycle)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+func (t *Tcr) stats() error {
+	var cycles []cycle
+	if err := t.history(0, func(c *object.Commit) error {
+		if cycle, ok := parseCycle(c); ok {
+			cycles = append(cycles, cycle)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	fmt.Printf("cycles:         %d\n", len(cycles))
+	if len(cycles) == 0 {
+		return nil
+	}
+
+	var total time.Duration
+	var timed int
+	profiles := map[string]int{}
+	for _, c := range cycles {
+		if c.Duration > 0 {
+			total += c.Duration
+			timed++
+		}
+		if c.Profile != "" {
+			profiles[c.Profile]++
+		}
+	}
+
+	if timed > 0 {
+		fmt.Printf("test duration:  %s total, %s mean\n", total, (total / time.Duration(timed)).Round(time.Millisecond))
+	}
+
+	if passed := cycles[0].TestsPassed; passed >= 0 {
+		fmt.Printf("tests passed:   %d in the last cycle\n", passed)
+	}
+
+	if len(profiles) > 0 {
+		var names []string
+		for name, count := range profiles {
+			names = append(names, fmt.Sprintf("%s (%d)", name, count))
+		}
+		slices.Sort(names)
+		fmt.Printf("profiles:       %s\n", strings.Join(names, ", "))
+	}
+
+	return nil
+}
+
+func printCommit(c *object.Commit, note string) {
+	fmt.Printf("commit %s\n", c.Hash)
+	fmt.Printf("Author: %s <%s>\n", c.Author.Name, c.Author.Email)
+	fmt.Printf("Date:   %s\n\n", c.Author.When.Format(logDateFormat))
+	fmt.Println(indent(c.Message))
+
+	if note != "" {
+		fmt.Printf("\nNotes (tcr):\n%s\n", indent(note))
+	}
+}
+
+func indent(text string) string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = "    " + line
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
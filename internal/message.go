@@ -0,0 +1,193 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/go-git/go-git/v5/plumbing"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const defaultCommitMessage = "[WIP] refactoring"
+const defaultTicketPattern = `[A-Z][A-Z0-9]+-[0-9]+`
+
+var defaultConventionalTypes = []string{"build", "chore", "ci", "docs", "feat", "fix", "perf", "refactor", "revert", "style", "test"}
+
+var trailerPattern = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9-]*): (.*)$`)
+
+var conventionalHeader = regexp.MustCompile(`^([a-z]+)(?:\(([^()\s]+)\))?!?: \S`)
+
+type trailer struct {
+	Key   string
+	Value string
+}
+
+type messageData struct {
+	Branch   string
+	Files    []string
+	Step     int
+	Ticket   string
+	Duration time.Duration
+}
+
+func (t *Tcr) commitMessage(files []string) (string, error) {
+	msg := t.options.Message
+	if msg == "" {
+		data, err := t.messageData(files)
+		if err != nil {
+			return "", err
+		}
+
+		if msg, err = renderMessage(t.config.Commit.Message, data); err != nil {
+			return "", err
+		}
+	}
+
+	if c := t.config.Commit.Conventional; c != nil {
+		if err := validateConventional(msg, c); err != nil {
+			return "", err
+		}
+	}
+
+	return msg, nil
+}
+
+// checkCommitMessage renders the commit message before the tests run, so that
+// an invalid template or a message that is no conventional commit fails before
+// the tests. The message is rendered again for the commit, once the duration of
+// the tests is known.
+func (t *Tcr) checkCommitMessage() error {
+	_, err := t.commitMessage(t.snapshot.paths())
+	return err
+}
+
+func (t *Tcr) messageData(files []string) (messageData, error) {
+	step, err := t.step()
+	if err != nil {
+		return messageData{}, err
+	}
+
+	branch := t.branch()
+	ticket, err := ticketFromBranch(branch, t.config.Commit.TicketPattern)
+	if err != nil {
+		return messageData{}, err
+	}
+
+	return messageData{
+		Branch:   branch,
+		Files:    files,
+		Step:     step + 1,
+		Ticket:   ticket,
+		Duration: t.testDuration.Round(time.Millisecond),
+	}, nil
+}
+
+// branch returns the name of the checked out branch, which is known before
+// its first commit as well.
+func (t *Tcr) branch() string {
+	head, err := t.repo.Storer.Reference(plumbing.HEAD)
+	if err != nil || head.Type() != plumbing.SymbolicReference || !head.Target().IsBranch() {
+		return ""
+	}
+
+	return head.Target().Short()
+}
+
+func ticketFromBranch(branch string, pattern string) (string, error) {
+	if pattern == "" {
+		pattern = defaultTicketPattern
+	}
+
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid ticket pattern: %w", err)
+	}
+
+	return r.FindString(branch), nil
+}
+
+func renderMessage(tmpl string, data messageData) (string, error) {
+	if tmpl == "" {
+		tmpl = defaultCommitMessage
+	}
+
+	parsed, err := template.New("message").
+		Funcs(template.FuncMap{"join": strings.Join}).
+		Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("invalid commit message template: %w", err)
+	}
+
+	var out bytes.Buffer
+	if err := parsed.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("invalid commit message template: %w", err)
+	}
+
+	return out.String(), nil
+}
+
+func validateConventional(msg string, c *conventionalConfig) error {
+	header, _, _ := strings.Cut(msg, "\n")
+	match := conventionalHeader.FindStringSubmatch(header)
+	if match == nil {
+		return fmt.Errorf("commit message %q is not a conventional commit", header)
+	}
+
+	types := c.Types
+	if len(types) == 0 {
+		types = defaultConventionalTypes
+	}
+
+	if !slices.Contains(types, match[1]) {
+		return fmt.Errorf("commit type %q is not one of %s", match[1], strings.Join(types, ", "))
+	}
+
+	if len(c.Scopes) > 0 && match[2] != "" && !slices.Contains(c.Scopes, match[2]) {
+		return fmt.Errorf("commit scope %q is not one of %s", match[2], strings.Join(c.Scopes, ", "))
+	}
+
+	return nil
+}
+
+// appendTrailers adds the trailers to the trailer block of the message,
+// a new block is started if the message does not end with one.
+func appendTrailers(msg string, trailers []trailer) string {
+	if len(trailers) == 0 {
+		return msg
+	}
+
+	var lines []string
+	for _, t := range trailers {
+		lines = append(lines, t.Key+": "+t.Value)
+	}
+
+	msg = strings.TrimRight(msg, "\n")
+	if len(parseTrailers(msg)) > 0 {
+		return msg + "\n" + strings.Join(lines, "\n")
+	}
+
+	return msg + "\n\n" + strings.Join(lines, "\n")
+}
+
+// parseTrailers returns the trailers of the last paragraph of the message,
+// the subject line never contains trailers.
+func parseTrailers(msg string) []trailer {
+	paragraphs := strings.Split(strings.TrimRight(msg, "\n"), "\n\n")
+	if len(paragraphs) < 2 {
+		return nil
+	}
+
+	var result []trailer
+	for _, line := range strings.Split(paragraphs[len(paragraphs)-1], "\n") {
+		match := trailerPattern.FindStringSubmatch(line)
+		if match == nil {
+			return nil
+		}
+		result = append(result, trailer{Key: match[1], Value: match[2]})
+	}
+
+	return result
+}
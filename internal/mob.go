@@ -0,0 +1,259 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"io"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const mobFile = "mob.json"
+
+var identityPattern = regexp.MustCompile(`^\s*(.+?)\s*<([^<>]+)>\s*$`)
+
+type mob struct {
+	Members  []string      `json:"members"`
+	Rotation time.Duration `json:"rotation"`
+	Started  time.Time     `json:"started"`
+}
+
+func (m mob) turn(now time.Time) int {
+	return int(now.Sub(m.Started) / m.Rotation)
+}
+
+func (m mob) driver(now time.Time) string {
+	return m.Members[m.turn(now)%len(m.Members)]
+}
+
+func (m mob) next(now time.Time) string {
+	return m.Members[(m.turn(now)+1)%len(m.Members)]
+}
+
+func (m mob) nextRotation(now time.Time) time.Time {
+	return m.Started.Add(time.Duration(m.turn(now)+1) * m.Rotation)
+}
+
+// MobStart stores the roster of the mob and announces every rotation of the
+// driver until it is interrupted. The roster is kept after the announcements
+// have been stopped.
+func (t *Tcr) MobStart(members []string, rotation time.Duration) Result {
+	if err := t.openRepository(); err != nil {
+		t.logger.Err(err).Msg("error on opening git repository")
+		return Error
+	}
+
+	if err := t.readConfig(); err != nil {
+		t.logger.Err(err).Msg("error on reading configuration")
+		return Error
+	}
+
+	m, err := t.newMob(members, rotation)
+	if err != nil {
+		t.logger.Err(err).Msg("error on starting mob")
+		return Error
+	}
+
+	if err := t.writeMob(m); err != nil {
+		t.logger.Err(err).Msg("error on storing mob")
+		return Error
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	for {
+		now := time.Now()
+		t.logger.Info().Str("next", m.next(now)).Msgf("%s drives", m.driver(now))
+
+		select {
+		case <-signals:
+			t.logger.Info().Msg("mob announcements stopped, roster is kept")
+			return Success
+		case <-time.After(time.Until(m.nextRotation(now))):
+		}
+	}
+}
+
+// MobStop removes the roster, commits are authored by the default identity
+// afterwards.
+func (t *Tcr) MobStop() Result {
+	if err := t.openRepository(); err != nil {
+		t.logger.Err(err).Msg("error on opening git repository")
+		return Error
+	}
+
+	if err := t.removeState(mobFile); err != nil {
+		t.logger.Err(err).Msg("error on stopping mob")
+		return Error
+	}
+
+	t.logger.Info().Msg("mob stopped")
+	return Success
+}
+
+// MobStatus prints the current and the next driver.
+func (t *Tcr) MobStatus() Result {
+	if err := t.openRepository(); err != nil {
+		t.logger.Err(err).Msg("error on opening git repository")
+		return Error
+	}
+
+	m, err := t.readMob()
+	if err != nil {
+		t.logger.Err(err).Msg("error on reading mob")
+		return Error
+	} else if m == nil {
+		t.logger.Info().Msg("no mob started")
+		return Success
+	}
+
+	now := time.Now()
+	t.logger.Info().
+		Str("next", m.next(now)).
+		Dur("left", m.nextRotation(now).Sub(now).Round(time.Second)).
+		Msgf("%s drives", m.driver(now))
+	return Success
+}
+
+func (t *Tcr) newMob(members []string, rotation time.Duration) (mob, error) {
+	if len(members) == 0 {
+		return mob{}, errors.New("a mob needs at least one member")
+	}
+
+	if rotation <= 0 {
+		return mob{}, errors.New("rotation must be positive")
+	}
+
+	var identities []string
+	for _, member := range members {
+		identity, err := t.resolveMember(member)
+		if err != nil {
+			return mob{}, err
+		}
+		identities = append(identities, identity)
+	}
+
+	return mob{Members: identities, Rotation: rotation, Started: time.Now()}, nil
+}
+
+// resolveMember returns the identity of a mob member, given either as 'Name
+// <email>', as alias configured in mob.members or as name of an author of the
+// history.
+func (t *Tcr) resolveMember(member string) (string, error) {
+	if alias, ok := t.config.Mob.Members[member]; ok {
+		member = alias
+	}
+
+	if identityPattern.MatchString(member) {
+		return member, nil
+	}
+
+	identity, err := t.authorNamed(member)
+	if err != nil {
+		return "", err
+	} else if identity == "" {
+		return "", fmt.Errorf("mob member %q is neither of the form 'Name <email>', configured in mob.members nor an author of the history", member)
+	}
+
+	return identity, nil
+}
+
+// authorNamed returns the identity of the latest author of HEAD whose name,
+// first name or local part of the email equals the given name, ignoring case.
+// It is empty if no author matches.
+func (t *Tcr) authorNamed(name string) (string, error) {
+	head, err := t.repo.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	commits, err := t.repo.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		return "", err
+	}
+	defer commits.Close()
+
+	for {
+		c, err := commits.Next()
+		if errors.Is(err, io.EOF) {
+			return "", nil
+		} else if err != nil {
+			return "", err
+		}
+
+		author := c.Author
+		firstName, _, _ := strings.Cut(author.Name, " ")
+		localPart, _, _ := strings.Cut(author.Email, "@")
+		if strings.EqualFold(author.Name, name) || strings.EqualFold(firstName, name) || strings.EqualFold(localPart, name) {
+			return fmt.Sprintf("%s <%s>", author.Name, author.Email), nil
+		}
+	}
+}
+
+func (t *Tcr) readMob() (*mob, error) {
+	content, err := t.readState(mobFile)
+	if err != nil || content == "" {
+		return nil, err
+	}
+
+	var m mob
+	if err := json.Unmarshal([]byte(content), &m); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+func (t *Tcr) writeMob(m mob) error {
+	content, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return t.writeState(mobFile, string(content))
+}
+
+// applyMob authors the commit as the current driver and credits the whole mob
+// with co-author trailers, the driver included, so that every commit lists the
+// same mob whoever drives.
+func (t *Tcr) applyMob(msg string, opts *git.CommitOptions) (string, error) {
+	m, err := t.readMob()
+	if err != nil || m == nil {
+		return msg, err
+	}
+
+	now := time.Now()
+	author, err := parseIdentity(m.driver(now), now)
+	if err != nil {
+		return "", err
+	}
+	opts.Author = author
+
+	var trailers []trailer
+	for _, member := range m.Members {
+		trailers = append(trailers, trailer{Key: "Co-authored-by", Value: member})
+	}
+
+	return appendTrailers(msg, trailers), nil
+}
+
+func parseIdentity(identity string, when time.Time) (*object.Signature, error) {
+	match := identityPattern.FindStringSubmatch(identity)
+	if match == nil {
+		return nil, fmt.Errorf("invalid identity %q", identity)
+	}
+
+	return &object.Signature{Name: match[1], Email: match[2], When: when}, nil
+}
@@ -0,0 +1,208 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const notesRef = plumbing.ReferenceName("refs/notes/tcr")
+const notesMessage = "Notes added by 'tcr'"
+
+type notesConfig struct {
+	Enabled bool   `json:"enabled"`
+	Summary string `json:"summary"`
+}
+
+// note describes the test run of a commit.
+func (t *Tcr) note() (string, error) {
+	summary, err := testSummary(t.testOutput, t.config.Notes.Summary)
+	if err != nil {
+		return "", err
+	}
+
+	version := t.options.Version
+	if version == "" {
+		version = "dev"
+	}
+
+	note := fmt.Sprintf("command: %s\nduration: %s\nexit code: %d\ntcr version: %s\n",
+		strings.Join(t.testCommand, " "), t.testDuration.Round(time.Millisecond), t.testExitCode, version)
+	if summary != "" {
+		note += "\n" + summary + "\n"
+	}
+
+	return note, nil
+}
+
+// testSummary returns the lines of the test output matching the pattern, or
+// the last line of the output if there is no pattern.
+func testSummary(output string, pattern string) (string, error) {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimRight(line, " \r\t"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if pattern == "" {
+		if len(lines) == 0 {
+			return "", nil
+		}
+		return lines[len(lines)-1], nil
+	}
+
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid notes.summary: %w", err)
+	}
+
+	var summary []string
+	for _, line := range lines {
+		if r.MatchString(line) {
+			summary = append(summary, line)
+		}
+	}
+
+	return strings.Join(summary, "\n"), nil
+}
+
+// addNote attaches the note of the test run to the commit.
+func (t *Tcr) addNote(commit plumbing.Hash) error {
+	if !t.config.Notes.Enabled {
+		return nil
+	}
+
+	content, err := t.note()
+	if err != nil {
+		return err
+	}
+
+	return t.writeNote(commit, content)
+}
+
+// writeNote attaches the note to the commit with a new commit on the notes
+// reference, the way git notes does.
+func (t *Tcr) writeNote(commit plumbing.Hash, content string) error {
+	blob, err := t.storeBlob(content)
+	if err != nil {
+		return err
+	}
+
+	var parents []plumbing.Hash
+	var entries []object.TreeEntry
+	if tip, err := t.notesCommit(); err != nil {
+		return err
+	} else if tip != nil {
+		tree, err := tip.Tree()
+		if err != nil {
+			return err
+		}
+
+		parents = []plumbing.Hash{tip.Hash}
+		for _, e := range tree.Entries {
+			if e.Name != commit.String() {
+				entries = append(entries, e)
+			}
+		}
+	}
+
+	entries = append(entries, object.TreeEntry{Name: commit.String(), Mode: filemode.Regular, Hash: blob})
+	slices.SortFunc(entries, func(a, b object.TreeEntry) int { return strings.Compare(a.Name, b.Name) })
+
+	tree, err := t.storeObject(&object.Tree{Entries: entries})
+	if err != nil {
+		return err
+	}
+
+	author, committer := t.signatures(time.Now())
+	notes, err := t.storeObject(&object.Commit{
+		Author:       *author,
+		Committer:    *committer,
+		Message:      notesMessage,
+		TreeHash:     tree,
+		ParentHashes: parents,
+	})
+	if err != nil {
+		return err
+	}
+
+	return t.repo.Storer.SetReference(plumbing.NewHashReference(notesRef, notes))
+}
+
+// notesCommit returns the last commit of the notes reference, or nil if there
+// are no notes yet.
+func (t *Tcr) notesCommit() (*object.Commit, error) {
+	ref, err := t.repo.Reference(notesRef, true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return t.repo.CommitObject(ref.Hash())
+}
+
+// readNote returns the note of the commit, or an empty string if there is none.
+// Notes may be stored in a fan-out tree like git does for many notes.
+func (t *Tcr) readNote(notes *object.Commit, commit plumbing.Hash) (string, error) {
+	if notes == nil {
+		return "", nil
+	}
+
+	tree, err := notes.Tree()
+	if err != nil {
+		return "", err
+	}
+
+	name := commit.String()
+	for _, path := range []string{name, name[:2] + "/" + name[2:]} {
+		f, err := tree.File(path)
+		if errors.Is(err, object.ErrFileNotFound) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		return f.Contents()
+	}
+
+	return "", nil
+}
+
+func (t *Tcr) storeBlob(content string) (plumbing.Hash, error) {
+	obj := t.repo.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+
+	w, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if _, err := io.WriteString(w, content); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := w.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return t.repo.Storer.SetEncodedObject(obj)
+}
+
+func (t *Tcr) storeObject(o interface {
+	Encode(plumbing.EncodedObject) error
+}) (plumbing.Hash, error) {
+	obj := t.repo.Storer.NewEncodedObject()
+	if err := o.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return t.repo.Storer.SetEncodedObject(obj)
+}
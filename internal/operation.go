@@ -0,0 +1,39 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// pendingOperations are git operations which tcr must not interfere with. The
+// key is the file git creates within the git directory during the operation.
+var pendingOperations = []struct {
+	file        string
+	explanation string
+}{
+	{"MERGE_HEAD", "a merge is in progress, conclude it with 'git merge --continue' or 'git merge --abort'"},
+	{"rebase-merge", "a rebase is in progress, conclude it with 'git rebase --continue' or 'git rebase --abort'"},
+	{"rebase-apply", "a rebase or am is in progress, conclude it with 'git rebase --continue' or 'git rebase --abort'"},
+	{"CHERRY_PICK_HEAD", "a cherry-pick is in progress, conclude it with 'git cherry-pick --continue' or 'git cherry-pick --abort'"},
+	{"REVERT_HEAD", "a revert is in progress, conclude it with 'git revert --continue' or 'git revert --abort'"},
+	{"BISECT_LOG", "a bisect is in progress, conclude it with 'git bisect reset'"},
+}
+
+// pendingOperation returns the explanation of the git operation in progress,
+// or an empty string if there is none.
+func (t *Tcr) pendingOperation() (string, error) {
+	dir, err := t.gitDir()
+	if err != nil {
+		return "", err
+	}
+
+	for _, op := range pendingOperations {
+		if _, err := os.Stat(filepath.Join(dir, op.file)); err == nil {
+			return op.explanation, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+
+	return "", nil
+}
@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const stopPoll = 50 * time.Millisecond
+
+var errInterrupted = errors.New("tcr has been interrupted")
+
+// trapInterrupts catches SIGINT and SIGTERM until the returned function is
+// called, so that a cycle is never stopped halfway through a commit or a
+// revert.
+func (t *Tcr) trapInterrupts() func() {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	t.interrupts = interrupts
+
+	return func() { signal.Stop(interrupts) }
+}
+
+// interrupted reports whether tcr has received SIGINT or SIGTERM.
+func (t *Tcr) interrupted() bool {
+	if t.interruptedBy != nil {
+		return true
+	}
+
+	select {
+	case sig := <-t.interrupts:
+		t.interruptedBy = sig
+		return true
+	default:
+		return false
+	}
+}
+
+// runTests runs the test command in its own process group. If the tests do
+// not finish in time, the process group is stopped and errTimeout is
+// returned. If tcr is interrupted, the signal is forwarded to the process
+// group and errInterrupted is returned.
+func (t *Tcr) runTests(cmd *exec.Cmd) error {
+	startProcessGroup(cmd)
+	cmd.WaitDelay = t.config.Timeout.grace()
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	var timeout <-chan time.Time
+	if after := t.config.Timeout.after(); after > 0 {
+		timer := time.NewTimer(after)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case err := <-done:
+		if errors.Is(err, exec.ErrWaitDelay) && cmd.ProcessState.Success() {
+			// the tests have passed, only processes they left behind still hold
+			// the output
+			t.logger.Warn().Msgf("processes started by the tests still hold their output after %s, not waiting for them", t.config.Timeout.grace())
+			return nil
+		}
+		return err
+	case <-timeout:
+		t.logger.Warn().Msgf("tests have not finished within %s, stopping them", t.config.Timeout.After)
+		t.stopTests(cmd, done, syscall.SIGTERM)
+		return errTimeout
+	case sig := <-t.interrupts:
+		t.interruptedBy = sig
+		t.logger.Warn().Msgf("received %s, stopping the tests", sig)
+		t.stopTests(cmd, done, sig)
+		return errInterrupted
+	}
+}
+
+// stopTests sends the signal to the process group of the tests and kills what
+// is left of the group after the grace period. The group is killed even if the
+// tests have finished, the processes they spawned may ignore the signal.
+func (t *Tcr) stopTests(cmd *exec.Cmd, done <-chan error, sig os.Signal) {
+	if err := signalProcessGroup(cmd.Process, sig); err != nil {
+		t.logger.Debug().Err(err).Msg("error on stopping the tests")
+	}
+
+	grace := time.After(t.config.Timeout.grace())
+	select {
+	case <-done:
+	case <-grace:
+		t.killTests(cmd)
+		<-done
+		return
+	}
+
+	for processGroupAlive(cmd.Process) {
+		select {
+		case <-grace:
+			t.killTests(cmd)
+			return
+		case <-time.After(stopPoll):
+		}
+	}
+}
+
+func (t *Tcr) killTests(cmd *exec.Cmd) {
+	if err := killProcessGroup(cmd.Process); err != nil {
+		t.logger.Debug().Err(err).Msg("error on killing the tests")
+	}
+}
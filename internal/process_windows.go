@@ -0,0 +1,28 @@
+package internal
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+func startProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// signalProcessGroup kills the process tree right away, windows has no signal
+// a console process can handle to shut down gracefully.
+func signalProcessGroup(p *os.Process, _ os.Signal) error {
+	return killProcessGroup(p)
+}
+
+func killProcessGroup(p *os.Process) error {
+	return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(p.Pid)).Run()
+}
+
+// processGroupAlive is always false, the process tree has already been killed
+// by signalProcessGroup.
+func processGroupAlive(_ *os.Process) bool {
+	return false
+}
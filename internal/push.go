@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const defaultPushRemote = "origin"
+
+type autoPushConfig struct {
+	Remote string `json:"remote"`
+	Branch string `json:"branch"`
+}
+
+// push pushes the checked out branch to the configured remote branch, which
+// defaults to the branch of the same name.
+func (t *Tcr) push() error {
+	c := t.config.AutoPush
+	remoteName := c.Remote
+	if remoteName == "" {
+		remoteName = defaultPushRemote
+	}
+
+	local := t.branch()
+	if local == "" {
+		return errors.New("pushing requires a checked out branch")
+	}
+
+	target := c.Branch
+	if target == "" {
+		target = local
+	}
+
+	remote, err := t.repo.Remote(remoteName)
+	if err != nil {
+		return fmt.Errorf("remote %s: %w", remoteName, err)
+	}
+
+	auth, cred, err := t.pushAuth(remote.Config().URLs[0])
+	if err != nil {
+		return err
+	}
+
+	err = remote.Push(&git.PushOptions{
+		RemoteName: remoteName,
+		RefSpecs:   []gitconfig.RefSpec{gitconfig.RefSpec("refs/heads/" + local + ":refs/heads/" + target)},
+		Auth:       auth,
+	})
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		err = nil
+	}
+
+	if cred != "" {
+		if err == nil {
+			t.credential("approve", cred)
+		} else if errors.Is(err, transport.ErrAuthenticationRequired) || errors.Is(err, transport.ErrAuthorizationFailed) {
+			t.credential("reject", cred)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	t.logger.Info().Msgf("pushed %s to %s/%s", local, remoteName, target)
+	return nil
+}
+
+// pushAuth returns the authentication for the remote url. SSH remotes use the
+// ssh agent, HTTP remotes the credential helpers configured in git. The
+// credential is returned so that it can be approved or rejected afterwards.
+func (t *Tcr) pushAuth(url string) (transport.AuthMethod, string, error) {
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch endpoint.Protocol {
+	case "ssh":
+		if os.Getenv("SSH_AUTH_SOCK") == "" {
+			return nil, "", nil
+		}
+		auth, err := ssh.NewSSHAgentAuth(endpoint.User)
+		return auth, "", err
+	case "http", "https":
+		return t.credentialAuth(endpoint)
+	default:
+		return nil, "", nil
+	}
+}
+
+// credentialAuth asks the configured credential helpers of git for a username
+// and a password. Remotes without credentials are accessed anonymously.
+func (t *Tcr) credentialAuth(endpoint *transport.Endpoint) (transport.AuthMethod, string, error) {
+	host := endpoint.Host
+	if endpoint.Port != 0 {
+		host = fmt.Sprintf("%s:%d", host, endpoint.Port)
+	}
+
+	request := fmt.Sprintf("protocol=%s\nhost=%s\npath=%s\n", endpoint.Protocol, host, strings.TrimPrefix(endpoint.Path, "/"))
+	if endpoint.User != "" {
+		request += "username=" + endpoint.User + "\n"
+	}
+
+	out, err := t.gitCredential("fill", request)
+	if err != nil {
+		t.logger.Debug().Err(err).Msg("no credentials found, pushing anonymously")
+		return nil, "", nil
+	}
+
+	auth := &http.BasicAuth{}
+	for _, line := range strings.Split(out, "\n") {
+		if key, value, ok := strings.Cut(line, "="); ok && key == "username" {
+			auth.Username = value
+		} else if ok && key == "password" {
+			auth.Password = value
+		}
+	}
+
+	return auth, out, nil
+}
+
+func (t *Tcr) credential(action string, cred string) {
+	if _, err := t.gitCredential(action, cred); err != nil {
+		t.logger.Warn().Err(err).Msgf("error on %s of credentials", action)
+	}
+}
+
+func (t *Tcr) gitCredential(action string, input string) (string, error) {
+	wt, err := t.repo.Worktree()
+	if err != nil {
+		return "", err
+	}
+
+	var out, stderr bytes.Buffer
+	cmd := exec.Command("git", "credential", action)
+	cmd.Dir = wt.Filesystem.Root()
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.Stdin = strings.NewReader(input + "\n")
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git credential %s: %w: %s", action, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return out.String(), nil
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// resolveScope sets the directory a cycle is limited to, --scope wins over the
+// scope of the configuration. The scope is relative to the root of the
+// worktree, an empty scope is the whole worktree.
+func (t *Tcr) resolveScope() error {
+	scope := t.options.Scope
+	if scope == "" {
+		scope = t.config.Scope
+	}
+
+	scope = path.Clean(filepath.ToSlash(scope))
+	if path.IsAbs(scope) || scope == ".." || strings.HasPrefix(scope, "../") {
+		return fmt.Errorf("scope %s must be a directory inside the worktree", scope)
+	}
+
+	if scope == "." {
+		scope = ""
+	} else if t.config.Revert.Target == revertGreen {
+		// reverting to the last green commit moves HEAD, which would also revert
+		// the commits outside the scope
+		return fmt.Errorf("revert.target %s is not supported with a scope", revertGreen)
+	}
+
+	t.scope = scope
+	return nil
+}
+
+func (t *Tcr) inScope(name string) bool {
+	return t.scope == "" || name == t.scope || strings.HasPrefix(name, t.scope+"/")
+}
+
+// setAsideOutsideScope resets the index entries outside the scope, so that a
+// commit only contains the changes inside. The returned function puts them
+// back afterwards, changes staged elsewhere in the worktree are left alone.
+func (t *Tcr) setAsideOutsideScope() (func() error, error) {
+	if t.scope == "" {
+		return func() error { return nil }, nil
+	}
+
+	status, err := t.vcs.status()
+	if err != nil {
+		return nil, err
+	}
+
+	var outside []string
+	for name, untracked := range status {
+		if !untracked && !t.inScope(name) {
+			outside = append(outside, name)
+		}
+	}
+
+	saved, err := t.indexStates(outside)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.vcs.resetIndex(outside); err != nil {
+		return nil, err
+	}
+
+	return func() error { return t.vcs.setIndex(saved) }, nil
+}
@@ -0,0 +1,356 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const shadowRefPrefix = "refs/tcr-shadow/"
+
+// shadowRef returns the reference holding the shadow history of the checked
+// out branch.
+func (t *Tcr) shadowRef() (*plumbing.Reference, plumbing.ReferenceName, error) {
+	head, err := t.repo.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, "", errors.New("shadow commits require an initial commit")
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	if !head.Name().IsBranch() {
+		return nil, "", errors.New("shadow commits require a checked out branch")
+	}
+
+	name := plumbing.ReferenceName(shadowRefPrefix + head.Name().Short())
+	ref, err := t.repo.Reference(name, true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, name, nil
+	}
+
+	return ref, name, err
+}
+
+// shadowTip returns the last shadow commit, or the commit of HEAD if there is
+// no shadow history yet.
+func (t *Tcr) shadowTip() (*object.Commit, error) {
+	ref, _, err := t.shadowRef()
+	if err != nil {
+		return nil, err
+	}
+
+	if ref != nil {
+		return t.repo.CommitObject(ref.Hash())
+	}
+
+	head, err := t.repo.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	return t.repo.CommitObject(head.Hash())
+}
+
+// shadowChanges returns all paths whose content in the worktree differs from
+// the last shadow commit. Only paths that differ from the index or between HEAD
+// and the shadow commit can have changed.
+func (t *Tcr) shadowChanges() ([]string, error) {
+	wt, err := t.worktree()
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := t.worktreeChanges()
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := map[string]bool{}
+	for _, name := range changes {
+		candidates[name] = true
+	}
+
+	tip, err := t.shadowTip()
+	if err != nil {
+		return nil, err
+	}
+
+	tipTree, err := tip.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := t.repo.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	if head.Hash() != tip.Hash {
+		headCommit, err := t.repo.CommitObject(head.Hash())
+		if err != nil {
+			return nil, err
+		}
+
+		headTree, err := headCommit.Tree()
+		if err != nil {
+			return nil, err
+		}
+
+		changes, err := object.DiffTree(headTree, tipTree)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range changes {
+			if c.From.Name != "" {
+				candidates[c.From.Name] = true
+			}
+			if c.To.Name != "" {
+				candidates[c.To.Name] = true
+			}
+		}
+	}
+
+	var result []string
+	for name := range candidates {
+		if changed, err := differsFromTree(tipTree, wt.Filesystem.Root(), name); err != nil {
+			return nil, err
+		} else if changed {
+			result = append(result, name)
+		}
+	}
+	slices.Sort(result)
+
+	return result, nil
+}
+
+func differsFromTree(tree *object.Tree, root string, name string) (bool, error) {
+	entry, err := tree.FindEntry(name)
+	if errors.Is(err, object.ErrEntryNotFound) || errors.Is(err, object.ErrDirectoryNotFound) {
+		entry = nil
+	} else if err != nil {
+		return false, err
+	}
+
+	info, err := os.Lstat(filepath.Join(root, name))
+	if os.IsNotExist(err) {
+		return entry != nil, nil
+	} else if err != nil {
+		return false, err
+	} else if info.IsDir() {
+		// a directory differs if it has replaced a file, its files are compared
+		// on their own
+		return entry != nil && entry.Mode != filemode.Dir && entry.Mode != filemode.Submodule, nil
+	} else if entry == nil {
+		return true, nil
+	}
+
+	mode, err := filemode.NewFromOSFileMode(info.Mode())
+	if err != nil {
+		return false, err
+	}
+
+	var content []byte
+	if mode == filemode.Symlink {
+		link, err := os.Readlink(filepath.Join(root, name))
+		if err != nil {
+			return false, err
+		}
+		content = []byte(link)
+	} else if content, err = os.ReadFile(filepath.Join(root, name)); err != nil {
+		return false, err
+	}
+
+	return mode != entry.Mode || plumbing.ComputeHash(plumbing.BlobObject, content) != entry.Hash, nil
+}
+
+// shadowCommit commits the worktree on top of the shadow history. HEAD and the
+// index are restored afterwards, so that the checked out branch does not move.
+func (t *Tcr) shadowCommit(msg string, opts *git.CommitOptions) (hash plumbing.Hash, err error) {
+	_, name, err := t.shadowRef()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	tip, err := t.shadowTip()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	head, err := t.repo.Head()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	files, err := t.worktreeChanges()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	// the commit stages the files and the files edited during the tests
+	saved, err := t.indexStates(append(slices.Clone(files), t.edited...))
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	defer func() {
+		if restoreErr := t.vcs.setIndex(saved); err == nil {
+			err = restoreErr
+		}
+		if restoreErr := t.repo.Storer.SetReference(head); err == nil {
+			err = restoreErr
+		}
+	}()
+
+	opts.Parents = []plumbing.Hash{tip.Hash}
+	if hash, err = t.commitFiles(msg, opts, files); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return hash, t.repo.Storer.SetReference(plumbing.NewHashReference(name, hash))
+}
+
+// Publish moves the shadow history of the checked out branch onto the branch,
+// either by fast-forwarding the branch or by squashing the history into a
+// single commit.
+func (t *Tcr) Publish(squash bool) Result {
+	if err := t.openRepository(); err != nil {
+		t.logger.Err(err).Msg("error on opening git repository")
+		return Error
+	}
+
+	if err := t.readConfig(); err != nil {
+		t.logger.Err(err).Msg("error on reading configuration")
+		return Error
+	}
+
+	if unlock, err := t.lock(t.config.Lock.wait()); errors.Is(err, errBusy) {
+		t.logger.Error().Msgf("refusing to publish: %s", err)
+		return Busy
+	} else if err != nil {
+		t.logger.Err(err).Msg("error on acquiring the lock")
+		return Error
+	} else {
+		defer unlock()
+	}
+
+	if signer, err := t.loadSigner(); err != nil {
+		t.logger.Err(err).Msg("error on loading the signing key")
+		return Error
+	} else {
+		t.signer = signer
+	}
+
+	if err := t.loadIdentity(); err != nil {
+		t.logger.Err(err).Msg("error on resolving the identity")
+		return Error
+	}
+
+	if err := t.publish(squash); err != nil {
+		t.logger.Err(err).Msg("error on publishing shadow commits")
+		return Error
+	}
+
+	return Success
+}
+
+func (t *Tcr) publish(squash bool) error {
+	ref, name, err := t.shadowRef()
+	if err != nil {
+		return err
+	} else if ref == nil {
+		t.logger.Info().Msg("no shadow commits, nothing to publish")
+		return nil
+	}
+
+	head, err := t.repo.Head()
+	if err != nil {
+		return err
+	}
+
+	headCommit, err := t.repo.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+
+	tip, err := t.repo.CommitObject(ref.Hash())
+	if err != nil {
+		return err
+	}
+
+	if ok, err := headCommit.IsAncestor(tip); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("%s has moved since the shadow history has been started", head.Name().Short())
+	}
+
+	target := tip.Hash
+	if squash {
+		if target, err = t.squash(headCommit, tip); err != nil {
+			return err
+		}
+	}
+
+	changed, err := t.changedBetween(head.Hash(), target)
+	if err != nil {
+		return err
+	}
+
+	if err := t.repo.Storer.CheckAndSetReference(plumbing.NewHashReference(head.Name(), target), head); err != nil {
+		return err
+	}
+
+	// the worktree already contains the shadow commits, only the index is
+	// moved along with the branch
+	if err := t.vcs.resetIndex(changed); err != nil {
+		return err
+	}
+
+	if err := t.repo.Storer.RemoveReference(name); err != nil {
+		return err
+	}
+
+	t.logger.Info().Str("commit", target.String()).Msgf("published shadow commits onto %s", head.Name().Short())
+	return nil
+}
+
+func (t *Tcr) squash(base *object.Commit, tip *object.Commit) (plumbing.Hash, error) {
+	msg := t.options.Message
+	if msg == "" {
+		var subjects []string
+		for c := tip; c.Hash != base.Hash; {
+			subject, _, _ := strings.Cut(c.Message, "\n")
+			subjects = append([]string{subject}, subjects...)
+
+			parent, err := c.Parent(0)
+			if err != nil {
+				return plumbing.ZeroHash, err
+			}
+			c = parent
+		}
+		msg = fmt.Sprintf("squash %d tcr commits\n\n- %s", len(subjects), strings.Join(subjects, "\n- "))
+	}
+
+	author, committer := t.signatures(time.Now())
+	commit := &object.Commit{
+		Author:       *author,
+		Committer:    *committer,
+		Message:      msg,
+		TreeHash:     tip.TreeHash,
+		ParentHashes: []plumbing.Hash{base.Hash},
+	}
+
+	if err := signCommit(t.signer, commit); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return t.storeObject(commit)
+}
@@ -0,0 +1,221 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	signingOpenPGP = "openpgp"
+	signingSSH     = "ssh"
+)
+
+// sshSignatureNamespace is the namespace git uses for signing commits with ssh
+// keys, see https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig.
+const sshSignatureNamespace = "git"
+
+// loadSigner returns the signer configured by commit.gpgSign, gpg.format and
+// user.signingKey, or nil if commits are not signed. Only keys without a
+// passphrase can be loaded.
+func (t *Tcr) loadSigner() (git.Signer, error) {
+	if sign, err := t.gitConfigBool("commit", "gpgsign"); err != nil || !sign {
+		return nil, err
+	}
+
+	format, err := t.gitConfig("gpg", "format")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := t.gitConfig("user", "signingkey")
+	if err != nil {
+		return nil, err
+	} else if key == "" {
+		return nil, errors.New("commit.gpgSign is enabled, but user.signingKey is not set")
+	}
+
+	if strings.HasPrefix(key, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		key = filepath.Join(home, key[2:])
+	}
+
+	switch format {
+	case "", signingOpenPGP:
+		return loadOpenPGPSigner(key)
+	case signingSSH:
+		return loadSSHSigner(key)
+	default:
+		return nil, fmt.Errorf("signing with gpg.format %q is not supported, use %s or %s", format, signingOpenPGP, signingSSH)
+	}
+}
+
+// signCommit signs commits which are not created through the worktree.
+func signCommit(signer git.Signer, commit *object.Commit) error {
+	if signer == nil {
+		return nil
+	}
+
+	encoded := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(encoded); err != nil {
+		return err
+	}
+
+	r, err := encoded.Reader()
+	if err != nil {
+		return err
+	}
+
+	signature, err := signer.Sign(r)
+	if err != nil {
+		return err
+	}
+
+	commit.PGPSignature = string(signature)
+	return nil
+}
+
+type openPGPSigner struct {
+	entity *openpgp.Entity
+}
+
+func loadOpenPGPSigner(path string) (git.Signer, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("user.signingKey %q is no key file, export the key with 'gpg --armor --export-secret-keys' and point user.signingKey to it", path)
+	} else if err != nil {
+		return nil, err
+	}
+
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(content))
+	if err != nil {
+		if entities, err = openpgp.ReadKeyRing(bytes.NewReader(content)); err != nil {
+			return nil, fmt.Errorf("user.signingKey %q is no openpgp key: %w", path, err)
+		}
+	}
+
+	for _, e := range entities {
+		if e.PrivateKey == nil {
+			continue
+		}
+
+		if e.PrivateKey.Encrypted {
+			return nil, fmt.Errorf("openpgp key %q is protected by a passphrase, which is not supported", path)
+		}
+
+		return openPGPSigner{entity: e}, nil
+	}
+
+	return nil, fmt.Errorf("user.signingKey %q contains no private openpgp key", path)
+}
+
+func (s openPGPSigner) Sign(message io.Reader) ([]byte, error) {
+	var out bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&out, s.entity, message, nil); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+type sshSigner struct {
+	signer ssh.Signer
+}
+
+func loadSSHSigner(path string) (git.Signer, error) {
+	if strings.HasPrefix(path, "key::") || strings.HasPrefix(path, "ssh-") {
+		return nil, errors.New("user.signingKey contains a literal public key, which requires an ssh agent and is not supported, point it to a private key file instead")
+	}
+
+	if private := strings.TrimSuffix(path, ".pub"); private != path {
+		path = private
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("ssh key %q does not exist", path)
+	} else if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.ParsePrivateKey(content)
+	var passphraseMissing *ssh.PassphraseMissingError
+	if errors.As(err, &passphraseMissing) {
+		return nil, fmt.Errorf("ssh key %q is protected by a passphrase, which is not supported", path)
+	} else if err != nil {
+		return nil, fmt.Errorf("ssh key %q can not be read: %w", path, err)
+	}
+
+	return sshSigner{signer: signer}, nil
+}
+
+// Sign creates an armored ssh signature as ssh-keygen -Y sign does.
+func (s sshSigner) Sign(message io.Reader) ([]byte, error) {
+	digest := sha512.New()
+	if _, err := io.Copy(digest, message); err != nil {
+		return nil, err
+	}
+
+	signed := ssh.Marshal(struct {
+		Magic     [6]byte
+		Namespace string
+		Reserved  string
+		Hash      string
+		Digest    string
+	}{sshSignatureMagic(), sshSignatureNamespace, "", "sha512", string(digest.Sum(nil))})
+
+	var signature *ssh.Signature
+	var err error
+	if s.signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		algorithmSigner, ok := s.signer.(ssh.AlgorithmSigner)
+		if !ok {
+			return nil, errors.New("rsa key does not support sha512 signatures")
+		}
+		signature, err = algorithmSigner.SignWithAlgorithm(rand.Reader, signed, ssh.KeyAlgoRSASHA512)
+	} else {
+		signature, err = s.signer.Sign(rand.Reader, signed)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blob := ssh.Marshal(struct {
+		Magic     [6]byte
+		Version   uint32
+		PublicKey string
+		Namespace string
+		Reserved  string
+		Hash      string
+		Signature string
+	}{sshSignatureMagic(), 1, string(s.signer.PublicKey().Marshal()), sshSignatureNamespace, "", "sha512", string(ssh.Marshal(signature))})
+
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	var out strings.Builder
+	out.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	for len(encoded) > 70 {
+		out.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	out.WriteString(encoded + "\n-----END SSH SIGNATURE-----\n")
+
+	return []byte(out.String()), nil
+}
+
+func sshSignatureMagic() [6]byte {
+	return [6]byte{'S', 'S', 'H', 'S', 'I', 'G'}
+}
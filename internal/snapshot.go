@@ -0,0 +1,236 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// snapshot is the state of the changed files when the tests started.
+type snapshot struct {
+	head   plumbing.Hash
+	branch string
+	files  map[string]fileState
+}
+
+// fileState is the content of a file, the zero hash stands for a deleted file.
+type fileState struct {
+	hash plumbing.Hash
+	mode filemode.FileMode
+}
+
+// takeSnapshot records the changed files before the tests run. Their content
+// is stored in the object database, so that exactly the tested state can be
+// committed.
+func (t *Tcr) takeSnapshot() error {
+	wt, err := t.worktree()
+	if err != nil {
+		return err
+	}
+
+	head, err := t.headHash()
+	if err != nil {
+		return err
+	}
+
+	files, err := t.changedFiles()
+	if err != nil {
+		return err
+	}
+
+	s := &snapshot{head: head, branch: t.branch(), files: map[string]fileState{}}
+	for _, name := range files {
+		if s.files[name], err = t.fileState(wt.Filesystem.Root(), name, true); err != nil {
+			return err
+		}
+	}
+
+	t.snapshot = s
+	return nil
+}
+
+// paths returns the sorted paths of the snapshot.
+func (s *snapshot) paths() []string {
+	var files []string
+	for name := range s.files {
+		files = append(files, name)
+	}
+	slices.Sort(files)
+
+	return files
+}
+
+// fileState reads the state of a file of the worktree, if store is set its
+// content is written to the object database.
+func (t *Tcr) fileState(root string, name string, store bool) (fileState, error) {
+	path := filepath.Join(root, name)
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fileState{}, nil
+	} else if err != nil {
+		return fileState{}, err
+	}
+
+	var content []byte
+	mode := filemode.Regular
+	switch {
+	case info.IsDir():
+		if gitlink, err := t.isGitlink(name); err != nil {
+			return fileState{}, err
+		} else if !gitlink {
+			// the directory has replaced a file, the files inside are changes of
+			// their own
+			return fileState{}, nil
+		}
+
+		sub, err := git.PlainOpenWithOptions(path, &git.PlainOpenOptions{EnableDotGitCommonDir: true})
+		if err != nil {
+			return fileState{}, fmt.Errorf("%s is no submodule: %w", name, err)
+		}
+
+		head, err := sub.Head()
+		if err != nil {
+			return fileState{}, err
+		}
+		return fileState{hash: head.Hash(), mode: filemode.Submodule}, nil
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(path)
+		if err != nil {
+			return fileState{}, err
+		}
+		content, mode = []byte(filepath.ToSlash(link)), filemode.Symlink
+	default:
+		if content, err = os.ReadFile(path); err != nil {
+			return fileState{}, err
+		}
+		if info.Mode().Perm()&0o111 != 0 {
+			mode = filemode.Executable
+		}
+	}
+
+	if !store {
+		return fileState{hash: plumbing.ComputeHash(plumbing.BlobObject, content), mode: mode}, nil
+	}
+
+	hash, err := t.storeBlob(string(content))
+	return fileState{hash: hash, mode: mode}, err
+}
+
+// verifySnapshot fails if HEAD has moved since the snapshot was taken.
+func (t *Tcr) verifySnapshot() error {
+	head, err := t.headHash()
+	if err != nil {
+		return err
+	}
+
+	if head != t.snapshot.head || t.branch() != t.snapshot.branch {
+		return errors.New("HEAD has moved while the tests were running")
+	}
+
+	return nil
+}
+
+// concurrentEdits returns the files that have been changed since the snapshot
+// was taken.
+func (t *Tcr) concurrentEdits() ([]string, error) {
+	wt, err := t.worktree()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := t.changedFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var edited []string
+	for _, name := range files {
+		if _, ok := t.snapshot.files[name]; !ok {
+			edited = append(edited, name)
+		}
+	}
+
+	for name, state := range t.snapshot.files {
+		if current, err := t.fileState(wt.Filesystem.Root(), name, false); err != nil {
+			return nil, err
+		} else if current != state {
+			edited = append(edited, name)
+		}
+	}
+	slices.Sort(edited)
+
+	return edited, nil
+}
+
+// stageSnapshot sets the index entries of the files edited while the tests
+// were running to their tested state. Files that were not part of the
+// snapshot are set to their state in the last commit.
+func (t *Tcr) stageSnapshot() error {
+	if t.snapshot == nil || len(t.edited) == 0 {
+		return nil
+	}
+
+	head, err := t.headHash()
+	if err != nil {
+		return err
+	}
+
+	tree, err := t.treeOf(head)
+	if err != nil {
+		return err
+	}
+
+	states := map[string]fileState{}
+	for _, name := range t.edited {
+		state, ok := t.snapshot.files[name]
+		if !ok {
+			if state, err = treeState(tree, name); err != nil {
+				return err
+			}
+		}
+
+		states[name] = state
+	}
+
+	return t.vcs.setIndex(states)
+}
+
+func treeState(tree *object.Tree, name string) (fileState, error) {
+	entry, err := tree.FindEntry(name)
+	if errors.Is(err, object.ErrEntryNotFound) || errors.Is(err, object.ErrDirectoryNotFound) {
+		return fileState{}, nil
+	} else if err != nil {
+		return fileState{}, err
+	}
+
+	return fileState{hash: entry.Hash, mode: entry.Mode}, nil
+}
+
+// indexStates returns the index entries of the files, files the index does not
+// contain have the zero state.
+func (t *Tcr) indexStates(files []string) (map[string]fileState, error) {
+	idx, err := t.repo.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	states := map[string]fileState{}
+	for _, name := range files {
+		if entry, err := idx.Entry(name); err == nil {
+			states[name] = fileState{hash: entry.Hash, mode: entry.Mode}
+		} else if errors.Is(err, index.ErrEntryNotFound) {
+			states[name] = fileState{}
+		} else {
+			return nil, err
+		}
+	}
+
+	return states, nil
+}
@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"errors"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const stateDirName = "tcr"
+const stepFile = "step"
+const commonDirFile = "commondir"
+
+// gitDir returns the git directory of the worktree, which is a directory in
+// worktrees/<name> of the common git directory for linked worktrees.
+func (t *Tcr) gitDir() (string, error) {
+	s, ok := t.repo.Storer.(*filesystem.Storage)
+	if !ok {
+		return "", errors.New("repository storage is not backed by a filesystem")
+	}
+
+	return s.Filesystem().Root(), nil
+}
+
+// commonDir returns the git directory shared by all worktrees.
+func (t *Tcr) commonDir() (string, error) {
+	dir, err := t.gitDir()
+	if err != nil {
+		return "", err
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, commonDirFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return dir, nil
+	} else if err != nil {
+		return "", err
+	}
+
+	common := strings.TrimSpace(string(content))
+	if !filepath.IsAbs(common) {
+		common = filepath.Join(dir, common)
+	}
+
+	return filepath.Clean(common), nil
+}
+
+// stateDir returns the directory of the state of tcr, every worktree has its
+// own state.
+func (t *Tcr) stateDir() (string, error) {
+	dir, err := t.gitDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, stateDirName), nil
+}
+
+func (t *Tcr) readState(name string) (string, error) {
+	dir, err := t.stateDir()
+	if err != nil {
+		return "", err
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(content)), nil
+}
+
+func (t *Tcr) writeState(name string, content string) error {
+	dir, err := t.stateDir()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, name), []byte(content+"\n"), 0o644)
+}
+
+func (t *Tcr) removeState(name string) error {
+	dir, err := t.stateDir()
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(filepath.Join(dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (t *Tcr) step() (int, error) {
+	content, err := t.readState(stepFile)
+	if err != nil || content == "" {
+		return 0, err
+	}
+
+	return strconv.Atoi(content)
+}
+
+func (t *Tcr) incrementStep() error {
+	step, err := t.step()
+	if err != nil {
+		return err
+	}
+
+	return t.writeState(stepFile, strconv.Itoa(step+1))
+}
@@ -0,0 +1,261 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"strings"
+)
+
+const (
+	submodulesIgnore    = "ignore"
+	submodulesRecursive = "recursive"
+	submodulesRefuse    = "refuse"
+)
+
+type submodule struct {
+	path string
+	// recorded is the commit HEAD of the parent records for the submodule, or
+	// the zero hash if HEAD does not contain the submodule.
+	recorded plumbing.Hash
+	// tcr runs on the repository of the submodule, it is nil as long as the
+	// submodule has not been initialized.
+	tcr *Tcr
+}
+
+// submodules returns all submodules of the worktree. Their repositories are
+// only opened if submodules are not ignored.
+func (t *Tcr) submodules() ([]submodule, error) {
+	wt, err := t.repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+
+	subs, err := wt.Submodules()
+	if err != nil || len(subs) == 0 {
+		return nil, err
+	}
+
+	tree, err := t.headTree()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []submodule
+	for _, s := range subs {
+		sub := submodule{path: s.Config().Path}
+		if entry, err := tree.FindEntry(sub.path); err == nil {
+			sub.recorded = entry.Hash
+		}
+
+		if t.config.Submodules != "" && t.config.Submodules != submodulesIgnore {
+			repo, err := s.Repository()
+			if err != nil && !errors.Is(err, git.ErrSubmoduleNotInitialized) {
+				return nil, fmt.Errorf("submodule %s: %w", sub.path, err)
+			} else if err == nil {
+				if sub.tcr, err = t.forSubmodule(sub.path, repo); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		result = append(result, sub)
+	}
+
+	return result, nil
+}
+
+// forSubmodule returns a tcr running on the repository of a submodule with the
+// configuration, the identity and the signing key of the parent.
+func (t *Tcr) forSubmodule(path string, repo *git.Repository) (*Tcr, error) {
+	sub := &Tcr{
+		options:   t.options,
+		repo:      repo,
+		logger:    t.logger.With().Str("submodule", path).Logger(),
+		config:    t.config,
+		signer:    t.signer,
+		author:    t.author,
+		committer: t.committer,
+	}
+	sub.config.Commit.Shadow = false
+
+	v, err := sub.openVCS(t.config.VCS)
+	if err != nil {
+		return nil, err
+	}
+
+	sub.vcs = v
+	return sub, nil
+}
+
+// isGitlink reports whether the path is a submodule, either configured in
+// .gitmodules or recorded as a gitlink in the index. Other directories are
+// plain directories, even if they contain a repository.
+func (t *Tcr) isGitlink(name string) (bool, error) {
+	wt, err := t.repo.Worktree()
+	if err != nil {
+		return false, err
+	}
+
+	subs, err := wt.Submodules()
+	if err != nil {
+		return false, err
+	}
+
+	for _, s := range subs {
+		if s.Config().Path == name {
+			return true, nil
+		}
+	}
+
+	idx, err := t.repo.Storer.Index()
+	if err != nil {
+		return false, err
+	}
+
+	entry, err := idx.Entry(name)
+	if errors.Is(err, index.ErrEntryNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return entry.Mode == filemode.Submodule, nil
+}
+
+func (t *Tcr) headTree() (*object.Tree, error) {
+	head, err := t.repo.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return &object.Tree{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return t.treeOf(head.Hash())
+}
+
+// dirty reports whether the submodule has changes or has another commit
+// checked out than the parent records.
+func (s submodule) dirty() (bool, error) {
+	if s.tcr == nil {
+		return false, nil
+	}
+
+	if clean, err := s.tcr.cleanWorktree(); err != nil || !clean {
+		return !clean, err
+	}
+
+	head, err := s.tcr.repo.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return head.Hash() != s.recorded, nil
+}
+
+func insideSubmodule(name string, subs []submodule) bool {
+	return findSubmodule(name, subs) != nil
+}
+
+func findSubmodule(name string, subs []submodule) *submodule {
+	for i, s := range subs {
+		if name == s.path || strings.HasPrefix(name, s.path+"/") {
+			return &subs[i]
+		}
+	}
+
+	return nil
+}
+
+// guardSubmodules returns why tcr refuses to run because of a submodule, or an
+// empty string if the submodules do not prevent running.
+func (t *Tcr) guardSubmodules() (string, error) {
+	if t.config.Submodules != submodulesRefuse {
+		return "", nil
+	}
+
+	subs, err := t.submodules()
+	if err != nil {
+		return "", err
+	}
+
+	for _, s := range subs {
+		if !t.inScope(s.path) {
+			continue
+		} else if dirty, err := s.dirty(); err != nil {
+			return "", err
+		} else if dirty {
+			return fmt.Sprintf("submodule %s has changes, commit or revert them first", s.path), nil
+		}
+	}
+
+	return "", nil
+}
+
+// commitSubmodules commits the changes of every submodule, so that the parent
+// commits the new commits of the submodules afterwards.
+func (t *Tcr) commitSubmodules(msg string, opts *git.CommitOptions) error {
+	if t.config.Submodules != submodulesRecursive {
+		return nil
+	}
+
+	subs, err := t.submodules()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range subs {
+		if s.tcr == nil {
+			continue
+		}
+
+		if err := s.tcr.commitSubmodules(msg, opts); err != nil {
+			return err
+		}
+
+		files, err := s.tcr.changedFiles()
+		if err != nil {
+			return err
+		} else if len(files) == 0 {
+			continue
+		}
+
+		subOpts := *opts
+		subOpts.Parents = nil
+		if _, err := s.tcr.branchCommit(msg, &subOpts, files); err != nil {
+			return fmt.Errorf("submodule %s: %w", s.path, err)
+		}
+	}
+
+	return nil
+}
+
+// revertSubmodule reverts the changes of the submodule. A submodule that has
+// another commit checked out than the parent records is left at that commit.
+func (t *Tcr) revertSubmodule(s *submodule) error {
+	if s.tcr == nil {
+		return nil
+	}
+
+	if clean, err := s.tcr.cleanWorktree(); err != nil {
+		return err
+	} else if !clean {
+		if err := s.tcr.revert(); err != nil {
+			return fmt.Errorf("submodule %s: %w", s.path, err)
+		}
+	}
+
+	if dirty, err := s.dirty(); err != nil {
+		return err
+	} else if dirty {
+		t.logger.Warn().Msgf("submodule %s has another commit checked out than recorded", s.path)
+	}
+
+	return nil
+}
@@ -3,28 +3,42 @@ package internal
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/rs/zerolog"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 )
 
 type Result int
 
 const (
-	Error   Result = iota
-	Failure Result = iota
-	Success Result = iota
+	Error       Result = iota
+	Failure     Result = iota
+	Success     Result = iota
+	Blocked     Result = iota
+	Busy        Result = iota
+	Unpushed    Result = iota
+	Rejected    Result = iota
+	Interrupted Result = iota
 )
 
-type config struct {
-	Test string `json:"test"`
+type Options struct {
+	Message string
+	Version string
+	Scope   string
 }
 
-func New() *Tcr {
+func New(o Options) *Tcr {
 	return &Tcr{
+		options: o,
 		logger: zerolog.New(os.Stdout).
 			Output(zerolog.NewConsoleWriter()).
 			With().Timestamp().
@@ -33,9 +47,23 @@ func New() *Tcr {
 }
 
 type Tcr struct {
-	repo        *git.Repository
-	logger      zerolog.Logger
-	testCommand []string
+	options       Options
+	repo          *git.Repository
+	logger        zerolog.Logger
+	config        config
+	testCommand   []string
+	testDuration  time.Duration
+	testOutput    string
+	testExitCode  int
+	signer        git.Signer
+	author        object.Signature
+	committer     object.Signature
+	vcs           vcs
+	scope         string
+	snapshot      *snapshot
+	edited        []string
+	interrupts    chan os.Signal
+	interruptedBy os.Signal
 }
 
 func (t *Tcr) Run() Result {
@@ -49,6 +77,34 @@ func (t *Tcr) Run() Result {
 		return Error
 	}
 
+	if unlock, err := t.lock(t.config.Lock.wait()); errors.Is(err, errBusy) {
+		t.logger.Error().Msgf("refusing to run: %s", err)
+		return Busy
+	} else if err != nil {
+		t.logger.Err(err).Msg("error on acquiring the lock")
+		return Error
+	} else {
+		defer unlock()
+	}
+
+	defer t.trapInterrupts()()
+
+	if op, err := t.pendingOperation(); err != nil {
+		t.logger.Err(err).Msg("error on detecting git operations in progress")
+		return Error
+	} else if op != "" {
+		t.logger.Error().Msgf("refusing to run: %s", op)
+		return Blocked
+	}
+
+	if reason, err := t.guardSubmodules(); err != nil {
+		t.logger.Err(err).Msg("error on checking submodules")
+		return Error
+	} else if reason != "" {
+		t.logger.Error().Msgf("refusing to run: %s", reason)
+		return Blocked
+	}
+
 	if clean, err := t.cleanWorktree(); err != nil {
 		t.logger.Err(err).Msg("error on running tests")
 		return Error
@@ -57,33 +113,104 @@ func (t *Tcr) Run() Result {
 		return Success
 	}
 
-	if passed, err := t.test(); err != nil {
+	if reason, err := t.guardBranch(); err != nil {
+		t.logger.Err(err).Msg("error on checking the branch")
+		return Error
+	} else if reason != "" {
+		t.logger.Error().Msgf("refusing to run: %s", reason)
+		return Blocked
+	}
+
+	if signer, err := t.loadSigner(); err != nil {
+		t.logger.Err(err).Msg("error on loading the signing key")
+		return Error
+	} else {
+		t.signer = signer
+	}
+
+	if err := t.loadIdentity(); err != nil {
+		t.logger.Err(err).Msg("error on resolving the identity")
+		return Error
+	}
+
+	if err := t.takeSnapshot(); err != nil {
+		t.logger.Err(err).Msg("error on taking a snapshot of the worktree")
+		return Error
+	}
+
+	if err := t.checkCommitMessage(); err != nil {
+		t.logger.Err(err).Msg("error on creating the commit message")
+		return Error
+	}
+
+	passed, err := t.test()
+	if errors.Is(err, errInterrupted) || t.interrupted() {
+		t.logger.Warn().Msg("interrupted, the changes are neither committed nor reverted")
+		return Interrupted
+	} else if err != nil {
 		t.logger.Err(err).Msg("error on running tests")
 		return Error
-	} else if passed {
+	}
+
+	if err := t.verifySnapshot(); err != nil {
+		t.logger.Err(err).Msg("aborting the cycle, the worktree is kept")
+		return Error
+	}
+
+	if t.edited, err = t.concurrentEdits(); err != nil {
+		t.logger.Err(err).Msg("error on detecting changes made while the tests were running")
+		return Error
+	} else if len(t.edited) > 0 {
+		t.logger.Warn().Msgf("files have been changed while the tests were running: %s", strings.Join(t.edited, ", "))
+	}
+
+	if passed {
 		t.logger.Info().Msg("tests have passed, committing changes")
-		if err := t.commit(); err != nil {
+		var rejection *hookRejection
+		if err := t.commit(); errors.As(err, &rejection) {
+			t.logger.Error().Msgf("%s, keeping the changes", rejection)
+			return Rejected
+		} else if err != nil {
 			t.logger.Err(err).Msg("error on commit")
 			return Error
-		} else {
-			return Success
 		}
-	} else {
-		t.logger.Info().Msg("tests have failed, resetting worktree")
-		if err := t.revert(); err != nil {
-			t.logger.Err(err).Msg("error on reverting commit")
+
+		if len(t.edited) > 0 {
+			t.logger.Warn().Msg("only the tested state has been committed, the changes made while the tests were running are kept")
+		}
+
+		if t.config.AutoPush != nil {
+			if err := t.push(); err != nil {
+				t.logger.Err(err).Msg("error on pushing, the commit is kept locally")
+				return Unpushed
+			}
+		}
+
+		return Success
+	}
+
+	if len(t.edited) > 0 {
+		if location, err := t.backupEdits(); err != nil {
+			t.logger.Err(err).Msg("error on backing up the changes made while the tests were running, the worktree is kept")
 			return Error
 		} else {
-			return Failure
+			t.logger.Warn().Str("backup", location).Msg("changes made while the tests were running have been backed up")
 		}
 	}
 
+	t.logger.Info().Msg("tests have failed, resetting worktree")
+	if err := t.revert(); err != nil {
+		t.logger.Err(err).Msg("error on reverting commit")
+		return Error
+	}
+
+	return Failure
 }
 
 func (t *Tcr) openRepository() error {
 	t.logger.Trace().Msg("opening repository")
 
-	repo, err := git.PlainOpen(".")
+	repo, err := git.PlainOpenWithOptions(".", &git.PlainOpenOptions{EnableDotGitCommonDir: true})
 	if err != nil {
 		return err
 	}
@@ -106,22 +233,69 @@ func (t *Tcr) readConfig() error {
 		return err
 	}
 
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	v, err := t.openVCS(c.VCS)
+	if err != nil {
+		return err
+	}
+
+	t.config = c
 	t.testCommand = strings.Split(c.Test, " ")
-	return nil
+	t.vcs = v
+	return t.resolveScope()
 }
 
 func (t *Tcr) cleanWorktree() (bool, error) {
-	wt, err := t.repo.Worktree()
+	changes, err := t.changedFiles()
+	return len(changes) == 0, err
+}
+
+func (t *Tcr) changedFiles() ([]string, error) {
+	if t.config.Commit.Shadow {
+		return t.shadowChanges()
+	}
+
+	return t.worktreeChanges()
+}
+
+// worktreeChanges returns all paths of the scope that differ between HEAD and
+// the worktree. Submodules are only part of the changes if they are committed
+// recursively.
+func (t *Tcr) worktreeChanges() ([]string, error) {
+	status, err := t.vcs.status()
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	if status, err := wt.Status(); err != nil {
-		return false, err
-	} else {
-		return status.IsClean(), nil
+	subs, err := t.submodules()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for name := range status {
+		if t.inScope(name) && !insideSubmodule(name, subs) {
+			files = append(files, name)
+		}
 	}
 
+	if t.config.Submodules == submodulesRecursive {
+		for _, sub := range subs {
+			if !t.inScope(sub.path) {
+				continue
+			} else if dirty, err := sub.dirty(); err != nil {
+				return nil, err
+			} else if dirty {
+				files = append(files, sub.path)
+			}
+		}
+	}
+	slices.Sort(files)
+
+	return files, nil
 }
 
 func (t *Tcr) test() (bool, error) {
@@ -131,9 +305,32 @@ func (t *Tcr) test() (bool, error) {
 	cmd := exec.Command(t.testCommand[0], t.testCommand[1:]...)
 	cmd.Stdout = &out
 	cmd.Stderr = &out
-	err := cmd.Run()
 
-	if e, ok := err.(*exec.ExitError); ok && !e.Success() {
+	if t.config.Isolate {
+		dir, cleanup, err := t.isolatedCheckout()
+		if err != nil {
+			return false, err
+		}
+		defer cleanup()
+		cmd.Dir = dir
+	}
+
+	start := time.Now()
+	err := t.runTests(cmd)
+	t.testDuration = time.Since(start)
+	t.testOutput = out.String()
+	t.testExitCode = cmd.ProcessState.ExitCode()
+
+	if errors.Is(err, errInterrupted) {
+		return false, err
+	} else if errors.Is(err, errTimeout) {
+		fmt.Print(out.String())
+		if t.config.Timeout.Outcome == timeoutError {
+			return false, err
+		}
+		t.logger.Info().Err(err).Msg("test execution failed")
+		return false, nil
+	} else if e, ok := err.(*exec.ExitError); ok && !e.Success() {
 		t.logger.Info().Err(err).Msg("test execution failed")
 		fmt.Print(out.String())
 		return false, nil
@@ -148,28 +345,295 @@ func (t *Tcr) test() (bool, error) {
 func (t *Tcr) commit() error {
 	t.logger.Trace().Msg("commit")
 
-	wt, err := t.repo.Worktree()
+	files, err := t.changedFiles()
 	if err != nil {
 		return err
+	} else if t.snapshot != nil {
+		files = t.snapshot.paths()
 	}
 
-	if err := wt.AddGlob("*"); err != nil {
+	msg, err := t.commitMessage(files)
+	if err != nil {
+		return err
+	}
+
+	step, err := t.step()
+	if err != nil {
+		return err
+	}
+
+	trailers, err := t.cycleTrailers(step + 1)
+	if err != nil {
 		return err
 	}
+	msg = appendTrailers(msg, trailers)
 
-	_, err = wt.Commit("[WIP] refactoring", &git.CommitOptions{})
-	return err
+	author, committer := t.signatures(time.Now())
+	opts := &git.CommitOptions{Author: author, Committer: committer, Signer: t.signer}
+	if msg, err = t.applyMob(msg, opts); err != nil {
+		return err
+	}
+
+	if err := t.commitSubmodules(msg, opts); err != nil {
+		return err
+	}
+
+	var hash plumbing.Hash
+	if t.config.Commit.Shadow {
+		hash, err = t.shadowCommit(msg, opts)
+	} else {
+		hash, err = t.branchCommit(msg, opts, files)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := t.addNote(hash); err != nil {
+		return err
+	}
+
+	if !t.config.Commit.Shadow {
+		if err := t.markGreen(hash); err != nil {
+			return err
+		}
+	}
+
+	return t.incrementStep()
+}
+
+func (t *Tcr) branchCommit(msg string, opts *git.CommitOptions, files []string) (plumbing.Hash, error) {
+	switchBack, err := t.leaveProtectedBranch()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	hash, err := t.commitFiles(msg, opts, files)
+	if err != nil {
+		if switchErr := switchBack(); switchErr != nil {
+			return plumbing.ZeroHash, switchErr
+		}
+		return plumbing.ZeroHash, err
+	}
+
+	if t.detached() {
+		t.logger.Warn().Msgf("committed %s onto a detached HEAD, it is not part of any branch", hash)
+	}
+
+	return hash, nil
+}
+
+// commitFiles stages the files and commits them. Changes staged outside the
+// scope are set aside for the commit, files edited while the tests were
+// running are staged in their tested state. With commit.hooks enabled, the
+// commit hooks of the repository run in between like git commit runs them. If
+// a hook rejects the commit, the index is reset and the worktree is kept.
+func (t *Tcr) commitFiles(msg string, opts *git.CommitOptions, files []string) (hash plumbing.Hash, err error) {
+	restore, err := t.setAsideOutsideScope()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	defer func() {
+		if restoreErr := restore(); err == nil {
+			err = restoreErr
+		}
+	}()
+
+	if err := t.vcs.stage(files); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := t.stageSnapshot(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if t.config.Commit.Hooks {
+		var err error
+		if msg, err = t.runCommitHooks(msg); err != nil {
+			var rejection *hookRejection
+			if errors.As(err, &rejection) {
+				if resetErr := t.vcs.resetIndex(files); resetErr != nil {
+					return plumbing.ZeroHash, resetErr
+				}
+			}
+			return plumbing.ZeroHash, err
+		}
+	}
+
+	if hash, err = t.vcs.commit(msg, opts); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if t.config.Commit.Hooks {
+		if err := t.runHook(hookPostCommit); err != nil {
+			t.logger.Warn().Err(err).Msgf("%s hook failed", hookPostCommit)
+		}
+	}
+
+	return hash, nil
 }
 
 func (t *Tcr) revert() error {
 	t.logger.Trace().Msg("revert")
 
-	worktree, err := t.repo.Worktree()
+	wt, err := t.worktree()
+	if err != nil {
+		return err
+	}
+
+	commit, err := t.revertCommit()
 	if err != nil {
+		return err
+	}
+
+	tree, err := t.treeOf(commit)
+	if err != nil {
+		return err
+	}
+
+	changes, err := t.changedFiles()
+	if err != nil {
+		return err
+	}
+
+	status, err := t.vcs.status()
+	if err != nil {
+		return err
+	}
+
+	subs, err := t.submodules()
+	if err != nil {
+		return err
+	}
+
+	if head, err := t.headHash(); err != nil {
+		return err
+	} else if head != commit {
+		unverified, err := t.resetHead(commit, subs)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range unverified {
+			if !slices.Contains(changes, name) {
+				changes = append(changes, name)
+			}
+		}
+	}
+
+	var restore, untracked []string
+	for _, name := range changes {
+		if sub := findSubmodule(name, subs); sub != nil {
+			if err := t.revertSubmodule(sub); err != nil {
+				return err
+			}
+		} else if _, err := tree.FindEntry(name); err != nil && status[name] {
+			untracked = append(untracked, name)
+		} else {
+			restore = append(restore, name)
+		}
+	}
+
+	// untracked files go first, they may be inside a directory that has
+	// replaced a tracked file
+	if err := t.revertUntracked(wt.Filesystem.Root(), untracked); err != nil {
+		return err
+	}
+
+	if err := t.vcs.restore(commit, restore); err != nil {
+		return err
+	}
+
+	if !t.config.Commit.Shadow {
+		return t.vcs.resetIndex(changes)
+	}
+
+	return nil
+}
+
+// revertCommit returns the commit the worktree is reverted to, or the zero
+// hash if there is no commit yet.
+func (t *Tcr) revertCommit() (plumbing.Hash, error) {
+	if t.config.Commit.Shadow {
+		tip, err := t.shadowTip()
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		return tip.Hash, nil
+	}
+
+	if green, err := t.greenRevertCommit(); err != nil || !green.IsZero() {
+		return green, err
+	}
+
+	head, err := t.repo.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return plumbing.ZeroHash, nil
+	} else if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return head.Hash(), nil
+}
+
+// treeOf returns the tree of the commit, the zero hash stands for an empty
+// tree.
+func (t *Tcr) treeOf(hash plumbing.Hash) (*object.Tree, error) {
+	if hash.IsZero() {
+		return &object.Tree{}, nil
+	}
+
+	commit, err := t.repo.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return commit.Tree()
+}
+
+// revertUntracked applies the configured policy to files that are neither
+// tracked nor ignored.
+func (t *Tcr) revertUntracked(root string, untracked []string) error {
+	if len(untracked) == 0 {
 		return nil
 	}
 
-	return worktree.Reset(&git.ResetOptions{
-		Mode: git.HardReset,
-	})
+	switch t.config.Revert.Untracked {
+	case untrackedKeep:
+		t.logger.Info().Strs("files", untracked).Msg("keeping untracked files")
+		return nil
+	case untrackedBackup:
+		location, err := t.backupFiles(root, untracked)
+		if err != nil {
+			return err
+		}
+		t.logger.Info().Str("backup", location).Msg("moved untracked files into backup")
+	}
+
+	for _, name := range untracked {
+		if err := removeFile(root, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeFile removes the file and all of its parent directories that are
+// empty afterwards.
+func removeFile(root string, name string) error {
+	if err := os.Remove(filepath.Join(root, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for dir := filepath.Dir(name); dir != "."; dir = filepath.Dir(dir) {
+		if entries, err := os.ReadDir(filepath.Join(root, dir)); err != nil || len(entries) > 0 {
+			return nil
+		} else if err := os.Remove(filepath.Join(root, dir)); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
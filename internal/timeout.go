@@ -0,0 +1,34 @@
+package internal
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	timeoutFailure = "failure"
+	timeoutError   = "error"
+)
+
+const defaultTimeoutGrace = 5 * time.Second
+
+var errTimeout = errors.New("tests have timed out")
+
+type timeoutConfig struct {
+	After   string `json:"after"`
+	Grace   string `json:"grace"`
+	Outcome string `json:"outcome"`
+}
+
+func (c timeoutConfig) after() time.Duration {
+	d, _ := time.ParseDuration(c.After)
+	return d
+}
+
+func (c timeoutConfig) grace() time.Duration {
+	if d, err := time.ParseDuration(c.Grace); err == nil {
+		return d
+	}
+
+	return defaultTimeoutGrace
+}
@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5/plumbing"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const timerTick = time.Second
+
+// Timer reverts the worktree whenever it has not been committed within the
+// given limit. Every green cycle restarts the countdown, commits made by hand
+// or a revert moving HEAD do not.
+func (t *Tcr) Timer(limit time.Duration) Result {
+	if err := t.openRepository(); err != nil {
+		t.logger.Err(err).Msg("error on opening git repository")
+		return Error
+	}
+
+	if err := t.readConfig(); err != nil {
+		t.logger.Err(err).Msg("error on reading configuration")
+		return Error
+	}
+
+	step, err := t.step()
+	if err != nil {
+		t.logger.Err(err).Msg("error on reading the step")
+		return Error
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	ticker := time.NewTicker(timerTick)
+	defer ticker.Stop()
+
+	t.logger.Info().Dur("limit", limit).Msg("timer started")
+	deadline := time.Now().Add(limit)
+	for {
+		select {
+		case <-signals:
+			fmt.Println()
+			t.logger.Info().Msg("timer stopped")
+			return Success
+		case now := <-ticker.C:
+			if current, err := t.step(); err != nil {
+				fmt.Println()
+				t.logger.Err(err).Msg("error on reading the step")
+				return Error
+			} else if current != step {
+				step = current
+				deadline = now.Add(limit)
+				fmt.Println()
+				t.logger.Info().Msg("green cycle, timer restarted")
+			}
+
+			if now.Before(deadline) {
+				fmt.Printf("\r%s left ", formatRemaining(deadline.Sub(now)))
+				continue
+			}
+
+			fmt.Println()
+			if err := t.expire(); err != nil {
+				t.logger.Err(err).Msg("error on reverting expired changes")
+				return Error
+			}
+			deadline = now.Add(limit)
+		}
+	}
+}
+
+func (t *Tcr) expire() error {
+	unlock, err := t.lock(0)
+	if errors.Is(err, errBusy) {
+		t.logger.Warn().Msgf("time is up, but not reverting: %s", err)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if op, err := t.pendingOperation(); err != nil {
+		return err
+	} else if op != "" {
+		t.logger.Warn().Msgf("time is up, but not reverting: %s", op)
+		return nil
+	}
+
+	if clean, err := t.cleanWorktree(); err != nil {
+		return err
+	} else if clean {
+		t.logger.Info().Msg("time is up, worktree is clean")
+		return nil
+	}
+
+	location, err := t.backup()
+	if err != nil {
+		return err
+	}
+
+	if err := t.revert(); err != nil {
+		return err
+	}
+
+	t.logger.Info().Str("backup", location).Msg("time is up, worktree has been reset")
+	return nil
+}
+
+// headHash returns the hash of the last commit, which is the last shadow
+// commit when committing to a shadow branch.
+func (t *Tcr) headHash() (plumbing.Hash, error) {
+	if t.config.Commit.Shadow {
+		tip, err := t.shadowTip()
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		return tip.Hash, nil
+	}
+
+	head, err := t.repo.Head()
+	if err == plumbing.ErrReferenceNotFound {
+		return plumbing.ZeroHash, nil
+	} else if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return head.Hash(), nil
+}
+
+func formatRemaining(d time.Duration) string {
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%02d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	trailerCycle       = "Tcr-Cycle"
+	trailerDuration    = "Tcr-Duration"
+	trailerCommand     = "Tcr-Command"
+	trailerProfile     = "Tcr-Profile"
+	trailerTestsPassed = "Tcr-Tests-Passed"
+)
+
+// trailerKeys maps the names used in commit.trailers to the keys of the
+// trailers.
+var trailerKeys = map[string]string{
+	"cycle":        trailerCycle,
+	"duration":     trailerDuration,
+	"command":      trailerCommand,
+	"profile":      trailerProfile,
+	"tests-passed": trailerTestsPassed,
+}
+
+const defaultTestsPassedPattern = `^\s*--- PASS`
+
+// cycle is the description of a tcr cycle parsed from the trailers of a
+// commit. Fields without a trailer are left empty, counts are -1.
+type cycle struct {
+	Hash        string
+	Cycle       int
+	Duration    time.Duration
+	Command     string
+	Profile     string
+	TestsPassed int
+}
+
+// cycleTrailers returns the configured trailers describing the current cycle.
+func (t *Tcr) cycleTrailers(step int) ([]trailer, error) {
+	var result []trailer
+	for _, name := range t.config.Commit.Trailers {
+		var value string
+		switch trailerKeys[name] {
+		case trailerCycle:
+			value = strconv.Itoa(step)
+		case trailerDuration:
+			value = t.testDuration.Round(time.Millisecond).String()
+		case trailerCommand:
+			value = strings.Join(t.testCommand, " ")
+		case trailerProfile:
+			value = t.config.Profile
+		case trailerTestsPassed:
+			passed, err := countPassedTests(t.testOutput, t.config.Commit.TestsPassedPattern)
+			if err != nil {
+				return nil, err
+			}
+			value = strconv.Itoa(passed)
+		}
+
+		if value != "" {
+			result = append(result, trailer{Key: trailerKeys[name], Value: value})
+		}
+	}
+
+	return result, nil
+}
+
+func countPassedTests(output string, pattern string) (int, error) {
+	if pattern == "" {
+		pattern = defaultTestsPassedPattern
+	}
+
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return 0, fmt.Errorf("invalid commit.testsPassedPattern: %w", err)
+	}
+
+	count := 0
+	for _, line := range strings.Split(output, "\n") {
+		if r.MatchString(line) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// parseCycle reads the trailers of a commit, it reports false for commits that
+// do not carry any tcr trailer.
+func parseCycle(c *object.Commit) (cycle, bool) {
+	result := cycle{Hash: c.Hash.String(), Cycle: -1, TestsPassed: -1}
+	found := false
+	for _, tr := range parseTrailers(c.Message) {
+		var err error
+		switch tr.Key {
+		case trailerCycle:
+			result.Cycle, err = strconv.Atoi(tr.Value)
+		case trailerDuration:
+			result.Duration, err = time.ParseDuration(tr.Value)
+		case trailerCommand:
+			result.Command = tr.Value
+		case trailerProfile:
+			result.Profile = tr.Value
+		case trailerTestsPassed:
+			result.TestsPassed, err = strconv.Atoi(tr.Value)
+		default:
+			continue
+		}
+		found = found || err == nil
+	}
+
+	return result, found
+}
+
+func (c cycle) String() string {
+	values := []string{c.Hash[:7], "-", "-", "-", c.Command, c.Profile}
+	if c.Cycle >= 0 {
+		values[1] = strconv.Itoa(c.Cycle)
+	}
+	if c.Duration > 0 {
+		values[2] = c.Duration.String()
+	}
+	if c.TestsPassed >= 0 {
+		values[3] = strconv.Itoa(c.TestsPassed)
+	}
+
+	return strings.TrimRight(strings.Join(values, "\t"), "\t")
+}
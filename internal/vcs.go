@@ -0,0 +1,271 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"os"
+	"path/filepath"
+)
+
+const (
+	vcsGoGit = "go-git"
+	vcsGit   = "git"
+)
+
+// vcs performs the operations of a cycle that read or write the worktree and
+// the index, only the vcs writes the index. References, objects and entries of
+// the index are read with go-git for every implementation.
+type vcs interface {
+	// status returns every path that differs between HEAD and the worktree,
+	// mapped to whether the path is untracked. Ignored files are left out.
+	status() (map[string]bool, error)
+	// stage adds the files to the index, including deletions and submodules.
+	stage(files []string) error
+	// commit commits the index onto HEAD, or onto opts.Parents if given, and
+	// moves HEAD to the new commit.
+	commit(msg string, opts *git.CommitOptions) (plumbing.Hash, error)
+	// restore sets the files to their state in the commit, files the commit does
+	// not contain are removed. The zero hash stands for an empty tree.
+	restore(commit plumbing.Hash, files []string) error
+	// resetIndex resets the files in the index to HEAD, they are removed from
+	// the index if there is no commit yet. Other files stay untouched.
+	resetIndex(files []string) error
+	// setIndex sets the index entries of the files to the states, a zero hash
+	// removes the file from the index. Other files stay untouched.
+	setIndex(states map[string]fileState) error
+}
+
+func (t *Tcr) openVCS(name string) (vcs, error) {
+	if name != vcsGit {
+		return goGitVCS{t: t}, nil
+	}
+
+	wt, err := t.repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+
+	return newGitCLI(wt.Filesystem.Root())
+}
+
+type goGitVCS struct {
+	t *Tcr
+}
+
+func (g goGitVCS) status() (map[string]bool, error) {
+	wt, err := g.t.worktree()
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := wt.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]bool{}
+	for name, s := range status {
+		if s.Staging != git.Unmodified || s.Worktree != git.Unmodified {
+			changes[name] = s.Worktree == git.Untracked
+		}
+	}
+
+	return changes, nil
+}
+
+func (g goGitVCS) commit(msg string, opts *git.CommitOptions) (plumbing.Hash, error) {
+	wt, err := g.t.worktree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return wt.Commit(msg, opts)
+}
+
+// stage adds the files to the index, including deletions and changes of the
+// file mode, and verifies that no change has been left behind. go-git does not
+// stage submodules on its own. Any other directory has replaced a file, which
+// is removed from the index, the files inside are changes of their own.
+func (g goGitVCS) stage(files []string) error {
+	wt, err := g.t.worktree()
+	if err != nil {
+		return err
+	}
+
+	root := wt.Filesystem.Root()
+	staged := map[string]bool{}
+	for _, name := range files {
+		if info, err := os.Lstat(filepath.Join(root, name)); err == nil && info.IsDir() {
+			if gitlink, err := g.t.isGitlink(name); err != nil {
+				return err
+			} else if gitlink {
+				err = g.stageGitlink(root, name)
+			} else {
+				err = g.unstageFile(name)
+			}
+
+			if err != nil {
+				return err
+			}
+		} else if err := wt.AddWithOptions(&git.AddOptions{Path: name, SkipStatus: true}); err != nil {
+			return err
+		} else {
+			staged[name] = true
+		}
+	}
+
+	status, err := wt.Status()
+	if err != nil {
+		return err
+	}
+
+	for name, s := range status {
+		if staged[name] && s.Worktree != git.Unmodified {
+			return fmt.Errorf("changes of %s could not be staged", name)
+		}
+	}
+
+	return nil
+}
+
+// stageGitlink records the commit checked out in the submodule in the index.
+func (g goGitVCS) stageGitlink(root string, name string) error {
+	sub, err := git.PlainOpenWithOptions(filepath.Join(root, name), &git.PlainOpenOptions{EnableDotGitCommonDir: true})
+	if err != nil {
+		return fmt.Errorf("%s is no submodule: %w", name, err)
+	}
+
+	head, err := sub.Head()
+	if err != nil {
+		return err
+	}
+
+	return g.setIndex(map[string]fileState{name: {hash: head.Hash(), mode: filemode.Submodule}})
+}
+
+// unstageFile removes the file from the index.
+func (g goGitVCS) unstageFile(name string) error {
+	return g.setIndex(map[string]fileState{name: {}})
+}
+
+func (g goGitVCS) setIndex(states map[string]fileState) error {
+	if len(states) == 0 {
+		return nil
+	}
+
+	idx, err := g.t.repo.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	for name, state := range states {
+		if state.hash.IsZero() {
+			if _, err := idx.Remove(name); err != nil && !errors.Is(err, index.ErrEntryNotFound) {
+				return err
+			}
+			continue
+		}
+
+		entry, err := idx.Entry(name)
+		if errors.Is(err, index.ErrEntryNotFound) {
+			entry = idx.Add(name)
+		} else if err != nil {
+			return err
+		}
+
+		*entry = index.Entry{Name: name, Hash: state.hash, Mode: state.mode}
+	}
+
+	return g.t.repo.Storer.SetIndex(idx)
+}
+
+func (g goGitVCS) restore(commit plumbing.Hash, files []string) error {
+	wt, err := g.t.worktree()
+	if err != nil {
+		return err
+	}
+
+	tree, err := g.t.treeOf(commit)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range files {
+		if err := restoreFromTree(tree, wt.Filesystem.Root(), name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g goGitVCS) resetIndex(files []string) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	if _, err := g.t.repo.Head(); errors.Is(err, plumbing.ErrReferenceNotFound) {
+		idx, err := g.t.repo.Storer.Index()
+		if err != nil {
+			return err
+		}
+
+		for _, name := range files {
+			if _, err := idx.Remove(name); err != nil && !errors.Is(err, index.ErrEntryNotFound) {
+				return err
+			}
+		}
+
+		return g.t.repo.Storer.SetIndex(idx)
+	} else if err != nil {
+		return err
+	}
+
+	wt, err := g.t.worktree()
+	if err != nil {
+		return err
+	}
+
+	return wt.Reset(&git.ResetOptions{Mode: git.MixedReset, Files: files})
+}
+
+// restoreFromTree sets the file to its state in the tree, the file is removed
+// if the tree does not contain it.
+func restoreFromTree(tree *object.Tree, root string, name string) error {
+	if err := removeFile(root, name); err != nil {
+		return err
+	}
+
+	f, err := tree.File(name)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	content, err := f.Contents()
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(root, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	if f.Mode == filemode.Symlink {
+		return os.Symlink(content, path)
+	}
+
+	mode, err := f.Mode.ToOSFileMode()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, []byte(content), mode.Perm())
+}
@@ -1,12 +1,20 @@
 package test
 
 import (
+	"fmt"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"io"
 	"os"
+	"os/exec"
 	"path"
+	"strings"
 )
 
+const AuthorName = "ci-user"
+const AuthorEmail = "ci@user.tld"
+
 func NewGitHelper(dir string) *GitHelper {
 	return &GitHelper{
 		dir: dir,
@@ -19,17 +27,15 @@ type GitHelper struct {
 }
 
 func (h *GitHelper) WithCommits() error {
-	repo, err := git.PlainInit(h.dir, false)
-	if err != nil {
+	if err := h.Init(); err != nil {
 		return err
 	}
-	h.repo = repo
 
 	if err := os.WriteFile(path.Join(h.dir, "README.md"), []byte("# Dummy"), os.ModePerm); err != nil {
 		return err
 	}
 
-	wt, err := repo.Worktree()
+	wt, err := h.repo.Worktree()
 	if err != nil {
 		return err
 	}
@@ -44,11 +50,9 @@ func (h *GitHelper) WithCommits() error {
 }
 
 func (h *GitHelper) InitRepositoryWithFiles(f Files) error {
-	repo, err := git.PlainInit(h.dir, false)
-	if err != nil {
+	if err := h.Init(); err != nil {
 		return err
 	}
-	h.repo = repo
 
 	for _, file := range f {
 		if err := os.WriteFile(path.Join(h.dir, file.Name), []byte(file.Content), os.ModePerm); err != nil {
@@ -56,7 +60,7 @@ func (h *GitHelper) InitRepositoryWithFiles(f Files) error {
 		}
 	}
 
-	wt, err := repo.Worktree()
+	wt, err := h.repo.Worktree()
 	if err != nil {
 		return err
 	}
@@ -88,7 +92,7 @@ func (h *GitHelper) IsWorkingTreeClean() (bool, error) {
 func (h *GitHelper) Commit() error {
 	if wt, err := h.repo.Worktree(); err != nil {
 		return err
-	} else if err := wt.AddGlob("*"); err != nil {
+	} else if err := wt.AddWithOptions(&git.AddOptions{All: true}); err != nil {
 		return err
 	} else {
 		_, err := wt.Commit("commit", &git.CommitOptions{})
@@ -103,7 +107,15 @@ func (h *GitHelper) Init() error {
 		return err
 	}
 	h.repo = repo
-	return nil
+
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+
+	cfg.User.Name = AuthorName
+	cfg.User.Email = AuthorEmail
+	return repo.SetConfig(cfg)
 }
 
 func (h *GitHelper) Head() (string, error) {
@@ -116,8 +128,10 @@ func (h *GitHelper) Head() (string, error) {
 
 type GitHistory []Commit
 type Commit struct {
-	Hash    string
-	Message string
+	Hash      string
+	Message   string
+	Author    string
+	Committer string
 }
 
 func (h *GitHelper) Commits() (GitHistory, error) {
@@ -129,8 +143,10 @@ func (h *GitHelper) Commits() (GitHistory, error) {
 	var result GitHistory
 	err = log.ForEach(func(c *object.Commit) error {
 		result = append(result, Commit{
-			Hash:    c.Hash.String(),
-			Message: c.Message,
+			Hash:      c.Hash.String(),
+			Message:   c.Message,
+			Author:    c.Author.Name + " <" + c.Author.Email + ">",
+			Committer: c.Committer.Name + " <" + c.Committer.Email + ">",
 		})
 		return nil
 	})
@@ -147,3 +163,192 @@ func (h *GitHelper) Add(name string) error {
 	return err
 
 }
+
+func (h *GitHelper) CheckoutNewBranch(name string) error {
+	worktree, err := h.repo.Worktree()
+	if err != nil {
+		return err
+	}
+
+	return worktree.Checkout(&git.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName(name),
+		Create: true,
+	})
+}
+
+func (h *GitHelper) ReferenceCommit(name string) (Commit, error) {
+	ref, err := h.repo.Reference(plumbing.ReferenceName(name), true)
+	if err != nil {
+		return Commit{}, err
+	}
+
+	c, err := h.repo.CommitObject(ref.Hash())
+	if err != nil {
+		return Commit{}, err
+	}
+
+	return Commit{
+		Hash:      c.Hash.String(),
+		Message:   c.Message,
+		Author:    c.Author.Name + " <" + c.Author.Email + ">",
+		Committer: c.Committer.Name + " <" + c.Committer.Email + ">",
+	}, nil
+}
+
+func (h *GitHelper) HasReference(name string) (bool, error) {
+	_, err := h.repo.Reference(plumbing.ReferenceName(name), true)
+	if err == plumbing.ErrReferenceNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h *GitHelper) HeadFile(name string) (*object.File, error) {
+	head, err := h.repo.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := h.repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := commit.File(name)
+	if err == object.ErrFileNotFound {
+		return nil, nil
+	}
+	return file, err
+}
+
+func (h *GitHelper) SetConfig(section string, key string, value string) error {
+	cfg, err := h.repo.Config()
+	if err != nil {
+		return err
+	}
+
+	cfg.Raw.Section(section).SetOption(key, value)
+	return h.repo.SetConfig(cfg)
+}
+
+func (h *GitHelper) DetachHead() error {
+	head, err := h.repo.Head()
+	if err != nil {
+		return err
+	}
+
+	return h.repo.Storer.SetReference(plumbing.NewHashReference(plumbing.HEAD, head.Hash()))
+}
+
+func (h *GitHelper) CurrentBranch() (string, error) {
+	head, err := h.repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return "", err
+	}
+
+	return head.Target().Short(), nil
+}
+
+func (h *GitHelper) headCommit() (*object.Commit, error) {
+	head, err := h.repo.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	return h.repo.CommitObject(head.Hash())
+}
+
+func (h *GitHelper) VerifyHead(armoredKeyRing string) error {
+	commit, err := h.headCommit()
+	if err != nil {
+		return err
+	}
+
+	_, err = commit.Verify(armoredKeyRing)
+	return err
+}
+
+func (h *GitHelper) HeadSignature() (string, string, error) {
+	commit, err := h.headCommit()
+	if err != nil {
+		return "", "", err
+	}
+
+	encoded := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(encoded); err != nil {
+		return "", "", err
+	}
+
+	r, err := encoded.Reader()
+	if err != nil {
+		return "", "", err
+	}
+
+	payload, err := io.ReadAll(r)
+	return commit.PGPSignature, string(payload), err
+}
+
+func (h *GitHelper) RemoveConfig(section string, key string) error {
+	cfg, err := h.repo.Config()
+	if err != nil {
+		return err
+	}
+
+	cfg.Raw.Section(section).RemoveOption(key)
+	if section == "user" && key == "name" {
+		cfg.User.Name = ""
+	} else if section == "user" && key == "email" {
+		cfg.User.Email = ""
+	}
+	return h.repo.SetConfig(cfg)
+}
+
+func (h *GitHelper) Open() error {
+	repo, err := git.PlainOpenWithOptions(h.dir, &git.PlainOpenOptions{EnableDotGitCommonDir: true})
+	if err != nil {
+		return err
+	}
+
+	h.repo = repo
+	return nil
+}
+
+// Git runs the git command line client in the repository.
+func (h *GitHelper) Git(args ...string) error {
+	_, err := h.GitOutput(args...)
+	return err
+}
+
+// GitOutput runs the git command line client in the repository and returns
+// its output.
+func (h *GitHelper) GitOutput(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = h.dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, out)
+	}
+
+	return string(out), nil
+}
+
+// HeadEntry returns the hash of the tree entry in HEAD, which is the recorded
+// commit for a submodule.
+func (h *GitHelper) HeadEntry(name string) (string, error) {
+	commit, err := h.headCommit()
+	if err != nil {
+		return "", err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return "", err
+	}
+
+	entry, err := tree.FindEntry(name)
+	if err != nil {
+		return "", err
+	}
+
+	return entry.Hash.String(), nil
+}
@@ -1,14 +1,37 @@
 package test_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
 	"github.com/jaedle/test-and-commit-or-revert/test"
+	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"os"
+	"os/exec"
 	"path"
+	"strconv"
+	"time"
 )
 
 const configFile = "tcr.json"
 
+// backend is the vcs every configuration written by the tests uses.
+var backend string
+
+func withBackend(config string) string {
+	var c map[string]any
+	if err := json.Unmarshal([]byte(config), &c); err != nil {
+		return config
+	}
+
+	c["vcs"] = backend
+	result, err := json.Marshal(c)
+	Expect(err).NotTo(HaveOccurred())
+	return string(result)
+}
+
 func givenAPassingTestSetup(workdir string, dir string, helper *test.GitHelper) {
 	givenAPassingTestSetupWithOutput(workdir, helper, "any")
 }
@@ -42,6 +65,10 @@ func givenATestSetupWithNonExecutableTests(workdir string, helper *test.GitHelpe
 
 func givenUnstangedChanges(workdir string, f test.Files) {
 	for _, file := range f {
+		if file.Name == configFile {
+			file.Content = withBackend(file.Content)
+		}
+		Expect(os.MkdirAll(path.Dir(path.Join(workdir, file.Name)), os.ModePerm)).NotTo(HaveOccurred())
 		Expect(os.WriteFile(path.Join(workdir, file.Name), []byte(file.Content), os.ModePerm)).NotTo(HaveOccurred())
 	}
 }
@@ -54,10 +81,7 @@ func givenStagedChanges(workdir string, helper *test.GitHelper, f test.Files) {
 }
 
 func givenACommit(workdir string, helper *test.GitHelper, f test.Files) {
-	for _, file := range f {
-		Expect(os.WriteFile(path.Join(workdir, file.Name), []byte(file.Content), os.ModePerm)).NotTo(HaveOccurred())
-	}
-
+	givenUnstangedChanges(workdir, f)
 	Expect(helper.Commit()).NotTo(HaveOccurred())
 }
 
@@ -102,3 +126,232 @@ func givenATestCommandThatNeedsArguments(gitHelper *test.GitHelper, workdir stri
 func givenAnyUnstagedChanges(workdir string) {
 	givenUnstangedChanges(workdir, test.Files{{Name: aFileName, Content: aContent}})
 }
+
+func givenAPassingTestSetupWithConfig(workdir string, helper *test.GitHelper, config string) {
+	Expect(helper.Init()).NotTo(HaveOccurred())
+
+	givenUnstangedChanges(workdir, test.Files{
+		{Name: configFile, Content: config},
+		{Name: "test.sh", Content: "#!/usr/bin/env bash\nexit 0"},
+	})
+
+	Expect(helper.Commit()).NotTo(HaveOccurred())
+}
+
+func givenABranch(helper *test.GitHelper, name string) {
+	Expect(helper.CheckoutNewBranch(name)).NotTo(HaveOccurred())
+}
+
+func givenDeletedFiles(workdir string, names ...string) {
+	for _, name := range names {
+		Expect(os.Remove(path.Join(workdir, name))).NotTo(HaveOccurred())
+	}
+}
+
+func givenARenamedFile(workdir string, from string, to string) {
+	Expect(os.Rename(path.Join(workdir, from), path.Join(workdir, to))).NotTo(HaveOccurred())
+}
+
+func givenAFileMode(workdir string, name string, mode os.FileMode) {
+	Expect(os.Chmod(path.Join(workdir, name), mode)).NotTo(HaveOccurred())
+}
+
+func givenAFailingTestSetupWithConfig(workdir string, helper *test.GitHelper, config string) {
+	Expect(helper.Init()).NotTo(HaveOccurred())
+
+	givenUnstangedChanges(workdir, test.Files{
+		{Name: configFile, Content: config},
+		{Name: "test.sh", Content: "#!/usr/bin/env bash\nexit 1"},
+	})
+
+	Expect(helper.Commit()).NotTo(HaveOccurred())
+}
+
+func givenAGitConfig(helper *test.GitHelper, section string, key string, value string) {
+	Expect(helper.SetConfig(section, key, value)).NotTo(HaveOccurred())
+}
+
+func givenAnEmptyRepository(workdir string, helper *test.GitHelper, testResult int) {
+	Expect(helper.Init()).NotTo(HaveOccurred())
+
+	givenUnstangedChanges(workdir, test.Files{
+		{Name: configFile, Content: `{"test": "./test.sh"}`},
+		{Name: "test.sh", Content: "#!/usr/bin/env bash\nexit " + strconv.Itoa(testResult)},
+	})
+}
+
+func givenAGitOperationInProgress(workdir string, marker string, isDir bool) {
+	p := path.Join(workdir, ".git", marker)
+	if isDir {
+		Expect(os.MkdirAll(p, os.ModePerm)).NotTo(HaveOccurred())
+	} else {
+		Expect(os.WriteFile(p, []byte("0000000000000000000000000000000000000000\n"), os.ModePerm)).NotTo(HaveOccurred())
+	}
+}
+
+func givenADetachedHead(helper *test.GitHelper) {
+	Expect(helper.DetachHead()).NotTo(HaveOccurred())
+}
+
+func givenAnOpenPGPSigningKey(helper *test.GitHelper, dir string) string {
+	entity, err := openpgp.NewEntity("ci-user", "", "ci@user.tld", nil)
+	Expect(err).NotTo(HaveOccurred())
+
+	var private bytes.Buffer
+	w, err := armor.Encode(&private, openpgp.PrivateKeyType, nil)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(entity.SerializePrivate(w, nil)).NotTo(HaveOccurred())
+	Expect(w.Close()).NotTo(HaveOccurred())
+
+	var public bytes.Buffer
+	w, err = armor.Encode(&public, openpgp.PublicKeyType, nil)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(entity.Serialize(w)).NotTo(HaveOccurred())
+	Expect(w.Close()).NotTo(HaveOccurred())
+
+	key := path.Join(dir, "key.asc")
+	Expect(os.WriteFile(key, private.Bytes(), 0o600)).NotTo(HaveOccurred())
+	givenAGitConfig(helper, "commit", "gpgSign", "true")
+	givenAGitConfig(helper, "user", "signingKey", key)
+
+	return public.String()
+}
+
+func givenAnSSHSigningKey(helper *test.GitHelper, dir string) string {
+	key := path.Join(dir, "id_ed25519")
+	Expect(exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", key).Run()).NotTo(HaveOccurred())
+	givenAGitConfig(helper, "commit", "gpgSign", "true")
+	givenAGitConfig(helper, "gpg", "format", "ssh")
+	givenAGitConfig(helper, "user", "signingKey", key+".pub")
+
+	return key + ".pub"
+}
+
+func givenNoIdentityInTheRepositoryConfiguration(helper *test.GitHelper) {
+	Expect(helper.RemoveConfig("user", "name")).NotTo(HaveOccurred())
+	Expect(helper.RemoveConfig("user", "email")).NotTo(HaveOccurred())
+}
+
+func givenAGlobalGitConfig(home string, content string) {
+	Expect(os.WriteFile(path.Join(home, ".gitconfig"), []byte(content), 0o644)).NotTo(HaveOccurred())
+}
+
+func givenASubmodule(workdir string, helper *test.GitHelper, name string) *test.GitHelper {
+	upstream, err := os.MkdirTemp(os.TempDir(), "tcr-workflow-test-submodule")
+	Expect(err).NotTo(HaveOccurred())
+	DeferCleanup(os.RemoveAll, upstream)
+	Expect(test.NewGitHelper(upstream).InitRepositoryWithFiles(test.Files{{Name: aFileName, Content: aContent}})).NotTo(HaveOccurred())
+
+	Expect(helper.Git("-c", "protocol.file.allow=always", "submodule", "add", "-q", upstream, name)).NotTo(HaveOccurred())
+	Expect(helper.Git("commit", "-q", "-m", "add submodule")).NotTo(HaveOccurred())
+
+	sub := test.NewGitHelper(path.Join(workdir, name))
+	Expect(sub.Open()).NotTo(HaveOccurred())
+	return sub
+}
+
+func givenALinkedWorktree(helper *test.GitHelper, branch string) (string, *test.GitHelper) {
+	dir, err := os.MkdirTemp(os.TempDir(), "tcr-workflow-test-linked-worktree")
+	Expect(err).NotTo(HaveOccurred())
+	DeferCleanup(os.RemoveAll, dir)
+
+	Expect(helper.Git("worktree", "add", "-q", "-b", branch, dir)).NotTo(HaveOccurred())
+
+	linked := test.NewGitHelper(dir)
+	Expect(linked.Open()).NotTo(HaveOccurred())
+	return dir, linked
+}
+
+func givenATestSetupThatBlocksUntil(workdir string, helper *test.GitHelper, release string, config string) {
+	Expect(helper.Init()).NotTo(HaveOccurred())
+
+	givenUnstangedChanges(workdir, test.Files{
+		{Name: configFile, Content: config},
+		{Name: "test.sh", Content: "#!/usr/bin/env bash\nwhile [ ! -f '" + release + "' ]; do sleep 0.05; done\nexit 0"},
+	})
+
+	Expect(helper.Commit()).NotTo(HaveOccurred())
+}
+
+func givenAStaleLock(workdir string) {
+	cmd := exec.Command("true")
+	Expect(cmd.Run()).NotTo(HaveOccurred())
+	host, err := os.Hostname()
+	Expect(err).NotTo(HaveOccurred())
+
+	Expect(os.MkdirAll(path.Join(workdir, ".git", "tcr"), os.ModePerm)).NotTo(HaveOccurred())
+	content := strconv.Itoa(cmd.ProcessState.Pid()) + "\n" + host + "\n"
+	Expect(os.WriteFile(path.Join(workdir, ".git", "tcr", "lock"), []byte(content), 0o644)).NotTo(HaveOccurred())
+}
+
+func givenAPassingTestSetupWithConfigAndOutput(workdir string, helper *test.GitHelper, config string, output string) {
+	Expect(helper.Init()).NotTo(HaveOccurred())
+
+	givenUnstangedChanges(workdir, test.Files{
+		{Name: configFile, Content: config},
+		{Name: "test.sh", Content: "#!/usr/bin/env bash\nprintf '%b' '" + output + "'\nexit 0"},
+	})
+
+	Expect(helper.Commit()).NotTo(HaveOccurred())
+}
+
+func givenARemote(helper *test.GitHelper, name string) *test.GitHelper {
+	dir, err := os.MkdirTemp(os.TempDir(), "tcr-workflow-test-remote")
+	Expect(err).NotTo(HaveOccurred())
+	DeferCleanup(os.RemoveAll, dir)
+
+	Expect(exec.Command("git", "init", "-q", "--bare", dir).Run()).NotTo(HaveOccurred())
+	Expect(helper.Git("remote", "add", name, dir)).NotTo(HaveOccurred())
+
+	remote := test.NewGitHelper(dir)
+	Expect(remote.Open()).NotTo(HaveOccurred())
+	return remote
+}
+
+func givenAHook(dir string, name string, script string) {
+	Expect(os.MkdirAll(dir, os.ModePerm)).NotTo(HaveOccurred())
+	Expect(os.WriteFile(path.Join(dir, name), []byte("#!/usr/bin/env bash\n"+script+"\n"), 0o755)).NotTo(HaveOccurred())
+}
+
+func givenATestThatRuns(workdir string, helper *test.GitHelper, config string, script string, exitCode int) {
+	Expect(helper.Init()).NotTo(HaveOccurred())
+
+	givenUnstangedChanges(workdir, test.Files{
+		{Name: configFile, Content: config},
+		{Name: "test.sh", Content: "#!/usr/bin/env bash\n" + script + "\nexit " + strconv.Itoa(exitCode)},
+	})
+
+	Expect(helper.Commit()).NotTo(HaveOccurred())
+}
+
+func givenACheckoutLeftBehind(workdir string, dir string) {
+	Expect(os.MkdirAll(dir, os.ModePerm)).NotTo(HaveOccurred())
+	Expect(os.WriteFile(path.Join(dir, aFileName), []byte(aContent), 0o644)).NotTo(HaveOccurred())
+
+	Expect(os.MkdirAll(path.Join(workdir, ".git", "tcr"), os.ModePerm)).NotTo(HaveOccurred())
+	Expect(os.WriteFile(path.Join(workdir, ".git", "tcr", "checkout"), []byte(dir+"\n"), 0o644)).NotTo(HaveOccurred())
+}
+
+func givenATestThatWaitsForSignals(workdir string, helper *test.GitHelper, config string, started string, trap string) {
+	givenATestThatRuns(workdir, helper, config, trap+"\ntouch '"+started+"'\nwhile true; do sleep 0.05; done", 0)
+}
+
+func givenTheUntrackedCacheIsEnabled(helper *test.GitHelper) {
+	Expect(helper.Git("config", "core.untrackedCache", "true")).NotTo(HaveOccurred())
+	Expect(helper.Git("update-index", "--untracked-cache")).NotTo(HaveOccurred())
+	Expect(helper.Git("status")).NotTo(HaveOccurred())
+}
+
+// givenTcrBranchesForTheNextSeconds creates the branches tcr would create at
+// HEAD within the next seconds.
+func givenTcrBranchesForTheNextSeconds(helper *test.GitHelper, seconds int) []string {
+	var branches []string
+	now := time.Now()
+	for i := 0; i < seconds; i++ {
+		branch := "tcr/" + now.Add(time.Duration(i)*time.Second).Format("20060102-150405")
+		Expect(helper.Git("branch", branch)).NotTo(HaveOccurred())
+		branches = append(branches, branch)
+	}
+
+	return branches
+}
@@ -1,10 +1,17 @@
 package test_test
 
 import (
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/jaedle/test-and-commit-or-revert/test"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
 	"os"
+	"os/exec"
 	"path"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 func thenItDoesNotDisplay(result tcrOutput, content string) {
@@ -66,3 +73,199 @@ func thenThoseFilesExist(workdir string, files test.Files) {
 func thenTestWasNotRun(dir string) {
 	Expect(path.Join(dir, "ran")).NotTo(BeAnExistingFile(), "test must not be run")
 }
+
+func thenEventuallyTheUnstagedChangesAreReset(workdir string, files test.Files) {
+	for _, f := range files {
+		Eventually(path.Join(workdir, f.Name)).WithTimeout(10 * time.Second).ShouldNot(BeAnExistingFile())
+	}
+}
+
+func thenABackupContains(workdir string, files test.Files) {
+	thenABackupInTheGitDirContains(path.Join(workdir, ".git"), files)
+}
+
+func thenABackupInTheGitDirContains(gitDir string, files test.Files) {
+	for _, f := range files {
+		matches, err := filepath.Glob(path.Join(gitDir, "tcr", "backups", "*", f.Name))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(matches).To(HaveLen(1), "backup must contain "+f.Name)
+
+		content, err := os.ReadFile(matches[0])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal(f.Content))
+	}
+}
+
+func thenTheLastCommitIsAuthoredBy(helper *test.GitHelper, author string) {
+	commits, err := helper.Commits()
+	Expect(err).NotTo(HaveOccurred())
+	Expect(commits[0].Author).To(Equal(author))
+}
+
+func thenTheReferenceHasMessage(helper *test.GitHelper, ref string, msg string) {
+	commit, err := helper.ReferenceCommit(ref)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(commit.Message).To(Equal(msg))
+}
+
+func thenTheReferenceDoesNotExist(helper *test.GitHelper, ref string) {
+	Expect(helper.HasReference(ref)).To(BeFalse(), ref+" must not exist")
+}
+
+func thenTheCommitContains(helper *test.GitHelper, files test.Files) {
+	for _, f := range files {
+		file, err := helper.HeadFile(f.Name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(file).NotTo(BeNil(), f.Name+" must be committed")
+
+		content, err := file.Contents()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(content).To(Equal(f.Content))
+	}
+}
+
+func thenOnlyThoseFilesAreStaged(helper *test.GitHelper, names ...string) {
+	staged, err := helper.GitOutput("diff", "--cached", "--name-only")
+	Expect(err).NotTo(HaveOccurred())
+	Expect(strings.Fields(staged)).To(Equal(names))
+}
+
+func thenTheCommitDoesNotContain(helper *test.GitHelper, names ...string) {
+	for _, name := range names {
+		Expect(helper.HeadFile(name)).To(BeNil(), name+" must not be committed")
+	}
+}
+
+func thenTheCommittedFileHasMode(helper *test.GitHelper, name string, mode filemode.FileMode) {
+	file, err := helper.HeadFile(name)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(file).NotTo(BeNil(), name+" must be committed")
+	Expect(file.Mode).To(Equal(mode))
+}
+
+func thenThereAreNoCommits(helper *test.GitHelper) {
+	_, err := helper.Head()
+	Expect(err).To(MatchError(plumbing.ErrReferenceNotFound))
+}
+
+func thenTcrExitsWith(o tcrOutput, code int) bool {
+	return Expect(o.exitCode).To(Equal(code))
+}
+
+func thenTheCurrentBranchStartsWith(helper *test.GitHelper, prefix string) {
+	branch, err := helper.CurrentBranch()
+	Expect(err).NotTo(HaveOccurred())
+	Expect(branch).To(HavePrefix(prefix))
+}
+
+func thenTheReferenceIsAt(helper *test.GitHelper, ref string, commit test.Commit) {
+	Expect(helper.ReferenceCommit(ref)).To(Equal(commit))
+}
+
+func thenTheLastCommitIsSignedWithOpenPGP(helper *test.GitHelper, publicKey string) {
+	Expect(helper.VerifyHead(publicKey)).To(Succeed())
+}
+
+func thenTheLastCommitIsSignedWithSSH(helper *test.GitHelper, dir string, publicKey string) {
+	signature, payload, err := helper.HeadSignature()
+	Expect(err).NotTo(HaveOccurred())
+	Expect(signature).To(HavePrefix("-----BEGIN SSH SIGNATURE-----"))
+
+	signatureFile := path.Join(dir, "commit.sig")
+	Expect(os.WriteFile(signatureFile, []byte(signature), 0o600)).NotTo(HaveOccurred())
+
+	cmd := exec.Command("ssh-keygen", "-Y", "check-novalidate", "-n", "git", "-s", signatureFile)
+	cmd.Stdin = strings.NewReader(payload)
+	out, err := cmd.CombinedOutput()
+	Expect(err).NotTo(HaveOccurred(), string(out))
+
+	fingerprint, err := exec.Command("ssh-keygen", "-l", "-f", publicKey).Output()
+	Expect(err).NotTo(HaveOccurred())
+	Expect(string(out)).To(ContainSubstring(strings.Fields(string(fingerprint))[1]))
+}
+
+func thenTheLastCommitIsCommittedBy(helper *test.GitHelper, committer string) {
+	commits, err := helper.Commits()
+	Expect(err).NotTo(HaveOccurred())
+	Expect(commits[0].Committer).To(Equal(committer))
+}
+
+func thenTheSubmoduleIsRecorded(helper *test.GitHelper, name string, sub *test.GitHelper) {
+	recorded, err := helper.HeadEntry(name)
+	Expect(err).NotTo(HaveOccurred())
+	head, err := sub.Head()
+	Expect(err).NotTo(HaveOccurred())
+	Expect(recorded).To(Equal(head))
+}
+
+func thenEventuallyTheLockIsHeld(workdir string) {
+	Eventually(path.Join(workdir, ".git", "tcr", "lock")).Should(BeAnExistingFile())
+}
+
+func thenTheLockIsReleased(workdir string) {
+	Expect(path.Join(workdir, ".git", "tcr", "lock")).NotTo(BeAnExistingFile())
+}
+
+func thenOnlyOneOfThemKeepsRunning(sessions []*gexec.Session) {
+	running := func() int {
+		n := 0
+		for _, s := range sessions {
+			if s.ExitCode() == -1 {
+				n++
+			}
+		}
+		return n
+	}
+
+	Eventually(running, 10*time.Second).Should(Equal(1))
+	Consistently(running, 500*time.Millisecond).Should(Equal(1))
+	for _, s := range sessions {
+		if s.ExitCode() != -1 {
+			Expect(s.ExitCode()).To(Equal(3))
+		}
+	}
+}
+
+func thenTheLastCommitHasANoteContaining(helper *test.GitHelper, contents ...string) {
+	note, err := helper.GitOutput("notes", "--ref", "tcr", "show", "HEAD")
+	Expect(err).NotTo(HaveOccurred())
+	for _, c := range contents {
+		Expect(note).To(ContainSubstring(c))
+	}
+}
+
+func thenTheLastCommitHasANoteNotContaining(helper *test.GitHelper, content string) {
+	note, err := helper.GitOutput("notes", "--ref", "tcr", "show", "HEAD")
+	Expect(err).NotTo(HaveOccurred())
+	Expect(note).NotTo(ContainSubstring(content))
+}
+
+func thenTheLastCommitMessageContains(helper *test.GitHelper, contents ...string) {
+	commits, err := helper.Commits()
+	Expect(err).NotTo(HaveOccurred())
+	for _, c := range contents {
+		Expect(commits[0].Message).To(ContainSubstring(c))
+	}
+}
+
+func thenTheRemoteBranchIsAtHead(remote *test.GitHelper, branch string, helper *test.GitHelper) {
+	head, err := helper.Head()
+	Expect(err).NotTo(HaveOccurred())
+	pushed, err := remote.ReferenceCommit("refs/heads/" + branch)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(pushed.Hash).To(Equal(head))
+}
+
+func thenTheLastGreenCommitIs(helper *test.GitHelper, commit string) {
+	green, err := helper.ReferenceCommit("refs/tcr/green")
+	Expect(err).NotTo(HaveOccurred())
+	Expect(green.Hash).To(Equal(commit))
+}
+
+// thenTheIndexHasTheUntrackedCache checks for the extension git keeps the
+// untracked cache in, go-git drops it when writing the index.
+func thenTheIndexHasTheUntrackedCache(workdir string) {
+	content, err := os.ReadFile(path.Join(workdir, ".git", "index"))
+	Expect(err).NotTo(HaveOccurred())
+	Expect(strings.Contains(string(content), "UNTR")).To(BeTrue(), "the index must keep the untracked cache")
+}
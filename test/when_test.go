@@ -4,8 +4,14 @@ import (
 	"bytes"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
 	"github.com/onsi/gomega/gexec"
+	"os"
 	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
 )
 
 type tcrOutput struct {
@@ -14,10 +20,32 @@ type tcrOutput struct {
 	stdErr   string
 }
 
-func whenIRunTcr(binary string, workdir string) tcrOutput {
-	cmd := exec.Command(binary)
+// home isolates tcr from the global git configuration of the machine running
+// the tests.
+var home string
+
+func tcrCommand(binary string, workdir string, env []string, args ...string) *exec.Cmd {
+	cmd := exec.Command(binary, args...)
 	cmd.Dir = workdir
 
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, "GIT_") && !strings.HasPrefix(e, "HOME=") && !strings.HasPrefix(e, "XDG_CONFIG_HOME=") && !strings.HasPrefix(e, "EMAIL=") {
+			cmd.Env = append(cmd.Env, e)
+		}
+	}
+	cmd.Env = append(cmd.Env, "HOME="+home, "XDG_CONFIG_HOME="+path.Join(home, ".config"))
+	cmd.Env = append(cmd.Env, env...)
+
+	return cmd
+}
+
+func whenIRunTcr(binary string, workdir string, args ...string) tcrOutput {
+	return whenIRunTcrWithEnvironment(binary, workdir, nil, args...)
+}
+
+func whenIRunTcrWithEnvironment(binary string, workdir string, env []string, args ...string) tcrOutput {
+	cmd := tcrCommand(binary, workdir, env, args...)
+
 	var stdOut bytes.Buffer
 	var stdErr bytes.Buffer
 	session, err := gexec.Start(cmd, &stdOut, &stdErr)
@@ -33,3 +61,41 @@ func whenIRunTcr(binary string, workdir string) tcrOutput {
 		stdErr:   stdErr.String(),
 	}
 }
+
+func whenIStartTcr(binary string, workdir string, args ...string) *gexec.Session {
+	session, err := gexec.Start(tcrCommand(binary, workdir, nil, args...), GinkgoWriter, GinkgoWriter)
+	Expect(err).NotTo(HaveOccurred())
+	return session
+}
+
+func whenIStartSeveralTcrsAtOnce(binary string, workdir string, n int) []*gexec.Session {
+	sessions := make([]*gexec.Session, n)
+	var started sync.WaitGroup
+	for i := range sessions {
+		started.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			defer started.Done()
+			sessions[i] = whenIStartTcr(binary, workdir)
+		}()
+	}
+	started.Wait()
+
+	return sessions
+}
+
+func whenIStartAMob(binary string, workdir string, args ...string) {
+	session := whenIStartTcr(binary, workdir, append([]string{"mob", "start"}, args...)...)
+	Eventually(session).Should(gbytes.Say("drives"))
+	session.Interrupt()
+	Eventually(session).Should(gexec.Exit(0))
+}
+
+func whenIRelease(release string) {
+	Expect(os.WriteFile(release, nil, 0o644)).NotTo(HaveOccurred())
+}
+
+func whenISignalTcrOnceTheTestsRun(session *gexec.Session, started string, signal os.Signal) {
+	Eventually(started, 5*time.Second).Should(BeAnExistingFile())
+	session.Signal(signal)
+}
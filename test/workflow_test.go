@@ -1,11 +1,17 @@
 package test_test
 
 import (
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/jaedle/test-and-commit-or-revert/test"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
 	"github.com/onsi/gomega/gexec"
 	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
 )
 
 const defaultCommitMessage = "[WIP] refactoring"
@@ -13,7 +19,7 @@ const aFileName = "new"
 const aContent = "some content"
 const anUpdatedContent = "updated content"
 
-var _ = Describe("Workflow", Ordered, func() {
+var _ = DescribeTableSubtree("Workflow", Ordered, func(vcs string) {
 	var binary string
 	var workdir string
 	var tempTestDir string
@@ -34,10 +40,17 @@ var _ = Describe("Workflow", Ordered, func() {
 		tmp2, err := os.MkdirTemp(os.TempDir(), "tcr-workflow-test-tmp-test-dir")
 		Expect(err).NotTo(HaveOccurred())
 		tempTestDir = tmp2
+
+		tmp3, err := os.MkdirTemp(os.TempDir(), "tcr-workflow-test-home")
+		Expect(err).NotTo(HaveOccurred())
+		home = tmp3
+
+		backend = vcs
 	})
 
 	AfterEach(func() {
 		_ = os.RemoveAll(workdir)
+		_ = os.RemoveAll(home)
 	})
 
 	AfterAll(func() {
@@ -75,6 +88,64 @@ var _ = Describe("Workflow", Ordered, func() {
 			})
 		})
 
+		Context("staging", func() {
+			It("commits deleted files", func() {
+				givenAPassingTestSetup(workdir, "", gitHelper)
+				givenACommit(workdir, gitHelper, test.Files{{Name: aFileName, Content: aContent}, {Name: "other", Content: aContent}})
+				history := givenAGitHistory(gitHelper)
+				givenDeletedFiles(workdir, aFileName)
+
+				result := whenIRunTcr(binary, workdir)
+
+				thenTcrSucceeds(result)
+				thenTheWorkingTreeIsClean(gitHelper)
+				thenANewCommitIsAdded(gitHelper, history, defaultCommitMessage)
+				thenTheCommitDoesNotContain(gitHelper, aFileName)
+				thenTheCommitContains(gitHelper, test.Files{{Name: "other", Content: aContent}})
+			})
+
+			It("commits renamed files", func() {
+				givenAPassingTestSetup(workdir, "", gitHelper)
+				givenACommit(workdir, gitHelper, test.Files{{Name: aFileName, Content: aContent}})
+				givenARenamedFile(workdir, aFileName, "renamed")
+
+				result := whenIRunTcr(binary, workdir)
+
+				thenTcrSucceeds(result)
+				thenTheWorkingTreeIsClean(gitHelper)
+				thenTheCommitDoesNotContain(gitHelper, aFileName)
+				thenTheCommitContains(gitHelper, test.Files{{Name: "renamed", Content: aContent}})
+			})
+
+			It("commits files in nested directories", func() {
+				givenAPassingTestSetup(workdir, "", gitHelper)
+				givenACommit(workdir, gitHelper, test.Files{{Name: "a/tracked", Content: aContent}, {Name: "a/b/deleted", Content: aContent}})
+				givenUnstangedChanges(workdir, test.Files{{Name: "a/tracked", Content: anUpdatedContent}, {Name: "a/b/c/new", Content: aContent}})
+				givenDeletedFiles(workdir, "a/b/deleted")
+
+				result := whenIRunTcr(binary, workdir)
+
+				thenTcrSucceeds(result)
+				thenTheWorkingTreeIsClean(gitHelper)
+				thenTheCommitContains(gitHelper, test.Files{{Name: "a/tracked", Content: anUpdatedContent}, {Name: "a/b/c/new", Content: aContent}})
+				thenTheCommitDoesNotContain(gitHelper, "a/b/deleted")
+			})
+
+			It("commits changes of the executable bit", func() {
+				givenAPassingTestSetupWithConfig(workdir, gitHelper, `{"test": "bash test.sh"}`)
+				givenAFileMode(workdir, "test.sh", 0o644)
+				Expect(gitHelper.Commit()).NotTo(HaveOccurred())
+				thenTheCommittedFileHasMode(gitHelper, "test.sh", filemode.Regular)
+				givenAFileMode(workdir, "test.sh", 0o755)
+
+				result := whenIRunTcr(binary, workdir)
+
+				thenTcrSucceeds(result)
+				thenTheWorkingTreeIsClean(gitHelper)
+				thenTheCommittedFileHasMode(gitHelper, "test.sh", filemode.Executable)
+			})
+		})
+
 		Context("test fails", func() {
 			It("removes untracked files", func() {
 				givenAFailingTestSetup(workdir, gitHelper)
@@ -130,9 +201,87 @@ var _ = Describe("Workflow", Ordered, func() {
 		})
 	})
 
-	Context("test execution fails", func() {
-		It("does not revert", func() {
-			givenATestSetupWithNonExecutableTests(workdir, gitHelper)
+	Context("ignored and untracked files on revert", func() {
+		ignored := test.Files{{Name: ".env", Content: "SECRET=1"}}
+
+		It("keeps files ignored by .gitignore", func() {
+			givenAFailingTestSetup(workdir, gitHelper)
+			givenACommit(workdir, gitHelper, test.Files{{Name: ".gitignore", Content: ".env\n"}})
+			givenUnstangedChanges(workdir, ignored)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenTheWorkingTreeIsClean(gitHelper)
+			thenTheUnstagedChangesAreReset(workdir, test.Files{{Name: aFileName, Content: aContent}})
+			thenThoseFilesExist(workdir, ignored)
+		})
+
+		It("keeps files excluded by .git/info/exclude", func() {
+			givenAFailingTestSetup(workdir, gitHelper)
+			givenUnstangedChanges(workdir, test.Files{{Name: ".git/info/exclude", Content: ".env\n"}})
+			givenUnstangedChanges(workdir, ignored)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenTheUnstagedChangesAreReset(workdir, test.Files{{Name: aFileName, Content: aContent}})
+			thenThoseFilesExist(workdir, ignored)
+		})
+
+		It("keeps files excluded by core.excludesFile", func() {
+			givenAFailingTestSetup(workdir, gitHelper)
+			givenUnstangedChanges(workdir, test.Files{{Name: ".git/excludes", Content: ".env\n"}})
+			givenAGitConfig(gitHelper, "core", "excludesFile", path.Join(workdir, ".git", "excludes"))
+			givenUnstangedChanges(workdir, ignored)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenTheUnstagedChangesAreReset(workdir, test.Files{{Name: aFileName, Content: aContent}})
+			thenThoseFilesExist(workdir, ignored)
+		})
+
+		It("removes untracked files in nested directories", func() {
+			givenAFailingTestSetup(workdir, gitHelper)
+			givenUnstangedChanges(workdir, test.Files{{Name: "a/b/new", Content: aContent}})
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenTheWorkingTreeIsClean(gitHelper)
+			thenTheUnstagedChangesAreReset(workdir, test.Files{{Name: "a"}})
+		})
+
+		It("keeps untracked files if configured", func() {
+			givenAFailingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "revert": {"untracked": "keep"}}`)
+			givenACommit(workdir, gitHelper, test.Files{{Name: "tracked", Content: aContent}})
+			givenUnstangedChanges(workdir, test.Files{{Name: "tracked", Content: anUpdatedContent}})
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenThoseFilesExist(workdir, test.Files{{Name: "tracked", Content: aContent}, {Name: aFileName, Content: aContent}})
+		})
+
+		It("moves untracked files into a backup if configured", func() {
+			givenAFailingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "revert": {"untracked": "backup"}}`)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenTheWorkingTreeIsClean(gitHelper)
+			thenTheUnstagedChangesAreReset(workdir, test.Files{{Name: aFileName, Content: aContent}})
+			thenABackupContains(workdir, test.Files{{Name: aFileName, Content: aContent}})
+		})
+
+		It("fails on an unknown policy", func() {
+			givenAFailingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "revert": {"untracked": "delete"}}`)
 			givenAnyUnstagedChanges(workdir)
 
 			result := whenIRunTcr(binary, workdir)
@@ -142,74 +291,1562 @@ var _ = Describe("Workflow", Ordered, func() {
 		})
 	})
 
-	Context("test commands", func() {
-		It("supports arguments", func() {
-			givenATestCommandThatNeedsArguments(gitHelper, workdir)
+	Context("repository without commits", func() {
+		It("creates the root commit if tests pass", func() {
+			givenAnEmptyRepository(workdir, gitHelper, 0)
 			givenAnyUnstagedChanges(workdir)
 
 			result := whenIRunTcr(binary, workdir)
 
 			thenTcrSucceeds(result)
+			thenTheWorkingTreeIsClean(gitHelper)
+			thenANewCommitIsAdded(gitHelper, test.GitHistory{}, defaultCommitMessage)
+			thenTheCommitContains(gitHelper, test.Files{{Name: aFileName, Content: aContent}})
+		})
+
+		It("removes only new files that are not ignored if tests fail", func() {
+			givenAnEmptyRepository(workdir, gitHelper, 1)
+			givenUnstangedChanges(workdir, test.Files{{Name: ".git/info/exclude", Content: ".env\n"}, {Name: ".env", Content: "SECRET=1"}})
+			givenStagedChanges(workdir, gitHelper, test.Files{{Name: "staged", Content: aContent}})
+			givenUnstangedChanges(workdir, test.Files{{Name: "a/b/new", Content: aContent}})
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenThereAreNoCommits(gitHelper)
+			thenTheUnstagedChangesAreReset(workdir, test.Files{{Name: configFile}, {Name: "test.sh"}, {Name: "staged"}, {Name: "a"}})
+			thenThoseFilesExist(workdir, test.Files{{Name: ".env", Content: "SECRET=1"}})
 		})
 	})
 
-	Context("test output", func() {
-		It("is swallowed if test passes", func() {
-			givenAPassingTestSetupWithOutput(workdir, gitHelper, "some random output")
+	Context("git operation in progress", func() {
+		DescribeTable("refuses to run",
+			func(marker string, isDir bool, explanation string) {
+				givenATestThatLogsRun(workdir, tempTestDir, gitHelper)
+				givenAGitOperationInProgress(workdir, marker, isDir)
+				givenAnyUnstagedChanges(workdir)
+
+				result := whenIRunTcr(binary, workdir)
+
+				thenTcrExitsWith(result, 2)
+				thenItDisplays(result, explanation)
+				thenTestWasNotRun(tempTestDir)
+				thenThoseFilesExist(workdir, test.Files{{Name: aFileName, Content: aContent}})
+			},
+			Entry("during a merge", "MERGE_HEAD", false, "a merge is in progress"),
+			Entry("during an interactive rebase", "rebase-merge", true, "a rebase is in progress"),
+			Entry("during a rebase", "rebase-apply", true, "a rebase or am is in progress"),
+			Entry("during a cherry-pick", "CHERRY_PICK_HEAD", false, "a cherry-pick is in progress"),
+			Entry("during a revert", "REVERT_HEAD", false, "a revert is in progress"),
+			Entry("during a bisect", "BISECT_LOG", false, "a bisect is in progress"),
+		)
+	})
+
+	Context("branches", func() {
+		It("refuses to commit onto a protected branch", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "branches": {"protected": ["main", "mas*"]}}`)
+			history := givenAGitHistory(gitHelper)
 			givenAnyUnstagedChanges(workdir)
 
 			result := whenIRunTcr(binary, workdir)
 
-			thenItDoesNotDisplay(result, "some random output")
+			thenTcrExitsWith(result, 2)
+			thenItDisplays(result, "branch master is protected")
+			thenTheHistoryIsUnchaged(gitHelper, history)
+			thenTheWorkingTreeIsNotClean(gitHelper)
 		})
 
-		It("is printed if test fails", func() {
-			givenAFailingTestSetupWithOutput(workdir, gitHelper, "some random output")
+		It("commits onto a new branch instead of a protected branch", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "branches": {"protected": ["master"], "onProtected": "branch"}}`)
+			history := givenAGitHistory(gitHelper)
 			givenAnyUnstagedChanges(workdir)
 
 			result := whenIRunTcr(binary, workdir)
 
-			thenItDisplays(result, "some random output")
+			thenTcrSucceeds(result)
+			thenTheWorkingTreeIsClean(gitHelper)
+			thenANewCommitIsAdded(gitHelper, history, defaultCommitMessage)
+			thenTheCurrentBranchStartsWith(gitHelper, "tcr/")
+			thenTheReferenceIsAt(gitHelper, "refs/heads/master", history[0])
+		})
+
+		It("does not overwrite an existing tcr branch", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "branches": {"protected": ["master"], "onProtected": "branch"}}`)
+			history := givenAGitHistory(gitHelper)
+			existing := givenTcrBranchesForTheNextSeconds(gitHelper, 5)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenTheCurrentBranchStartsWith(gitHelper, "tcr/")
+			for _, branch := range existing {
+				thenTheReferenceIsAt(gitHelper, "refs/heads/"+branch, history[0])
+			}
+		})
+
+		It("stays on the protected branch if a hook rejects the commit", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "commit": {"hooks": true}, "branches": {"protected": ["master"], "onProtected": "branch"}}`)
+			givenAHook(path.Join(workdir, ".git", "hooks"), "pre-commit", "exit 1")
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrExitsWith(result, 5)
+			thenTheCurrentBranchStartsWith(gitHelper, "master")
+			Expect(gitHelper.GitOutput("branch", "--list", "tcr/*")).To(BeEmpty())
+		})
+
+		It("refuses to commit onto a detached HEAD if configured", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "branches": {"detached": "refuse"}}`)
+			givenADetachedHead(gitHelper)
+			history := givenAGitHistory(gitHelper)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrExitsWith(result, 2)
+			thenItDisplays(result, "HEAD is detached")
+			thenTheHistoryIsUnchaged(gitHelper, history)
+		})
+
+		It("commits onto a detached HEAD and shows the commit", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			givenADetachedHead(gitHelper)
+			history := givenAGitHistory(gitHelper)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenANewCommitIsAdded(gitHelper, history, defaultCommitMessage)
+			head, err := gitHelper.Head()
+			Expect(err).NotTo(HaveOccurred())
+			thenItDisplays(result, "committed "+head+" onto a detached HEAD")
 		})
 	})
 
-	Context("clean worktree", func() {
-		It("does not create a new commit", func() {
+	Context("signing", func() {
+		It("signs commits with an openpgp key", func() {
 			givenAPassingTestSetup(workdir, "", gitHelper)
-			givenACommit(workdir, gitHelper, test.Files{{Name: aFileName, Content: aContent}})
-			commits := givenAGitHistory(gitHelper)
+			publicKey := givenAnOpenPGPSigningKey(gitHelper, tempTestDir)
+			givenAnyUnstagedChanges(workdir)
+
 			result := whenIRunTcr(binary, workdir)
 
 			thenTcrSucceeds(result)
-			thenTheWorkingTreeIsClean(gitHelper)
-			thenTheHistoryIsUnchaged(gitHelper, commits)
+			thenTheLastCommitIsSignedWithOpenPGP(gitHelper, publicKey)
 		})
 
-		It("does not test", func() {
+		It("signs commits with an ssh key", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			publicKey := givenAnSSHSigningKey(gitHelper, tempTestDir)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenTheLastCommitIsSignedWithSSH(gitHelper, tempTestDir, publicKey)
+		})
+
+		It("fails before testing if the signing key is missing", func() {
 			givenATestThatLogsRun(workdir, tempTestDir, gitHelper)
-			givenACommit(workdir, gitHelper, test.Files{{Name: aFileName, Content: aContent}})
+			givenAGitConfig(gitHelper, "commit", "gpgSign", "true")
+			givenAGitConfig(gitHelper, "user", "signingKey", path.Join(tempTestDir, "missing.asc"))
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenItDisplays(result, "is no key file")
+			thenTestWasNotRun(tempTestDir)
+			thenTheWorkingTreeIsNotClean(gitHelper)
+		})
+	})
+
+	Context("identity", func() {
+		It("uses the identity of the repository configuration", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			givenAGitConfig(gitHelper, "committer", "name", "Committer")
+			givenAnyUnstagedChanges(workdir)
+
 			result := whenIRunTcr(binary, workdir)
 
 			thenTcrSucceeds(result)
-			thenTheWorkingTreeIsClean(gitHelper)
+			thenTheLastCommitIsAuthoredBy(gitHelper, "ci-user <ci@user.tld>")
+			thenTheLastCommitIsCommittedBy(gitHelper, "Committer <ci@user.tld>")
+		})
+
+		It("falls back to the global configuration", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			givenNoIdentityInTheRepositoryConfiguration(gitHelper)
+			givenAGlobalGitConfig(home, "[user]\n\tname = Global\n\temail = global@example.com\n")
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenTheLastCommitIsAuthoredBy(gitHelper, "Global <global@example.com>")
+		})
+
+		It("prefers environment variables", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcrWithEnvironment(binary, workdir, []string{
+				"GIT_AUTHOR_NAME=Author", "GIT_AUTHOR_EMAIL=author@example.com",
+				"GIT_COMMITTER_NAME=Committer", "GIT_COMMITTER_EMAIL=committer@example.com",
+			})
+
+			thenTcrSucceeds(result)
+			thenTheLastCommitIsAuthoredBy(gitHelper, "Author <author@example.com>")
+			thenTheLastCommitIsCommittedBy(gitHelper, "Committer <committer@example.com>")
+		})
+
+		It("prefers the identity configured for tcr", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "identity": {"author": "Tcr <tcr@example.com>"}}`)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcrWithEnvironment(binary, workdir, []string{"GIT_AUTHOR_NAME=Author", "GIT_AUTHOR_EMAIL=author@example.com"})
+
+			thenTcrSucceeds(result)
+			thenTheLastCommitIsAuthoredBy(gitHelper, "Tcr <tcr@example.com>")
+			thenTheLastCommitIsCommittedBy(gitHelper, "ci-user <ci@user.tld>")
+		})
+
+		It("fails before testing without an identity", func() {
+			givenATestThatLogsRun(workdir, tempTestDir, gitHelper)
+			givenNoIdentityInTheRepositoryConfiguration(gitHelper)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenItDisplays(result, "no author identity found")
 			thenTestWasNotRun(tempTestDir)
 		})
 	})
 
-	Context("error cases", func() {
-		It("fails if not run within a git repository", func() {
+	Context("submodules", func() {
+		const submodule = "lib"
+		var changeInSubmodule = test.Files{{Name: path.Join(submodule, aFileName), Content: anUpdatedContent}}
+
+		It("ignores changes inside submodules by default", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			sub := givenASubmodule(workdir, gitHelper, submodule)
+			subHistory := givenAGitHistory(sub)
+			givenUnstangedChanges(workdir, changeInSubmodule)
+			givenAnyUnstagedChanges(workdir)
+			history := givenAGitHistory(gitHelper)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenANewCommitIsAdded(gitHelper, history, defaultCommitMessage)
+			thenTheCommitContains(gitHelper, test.Files{{Name: aFileName, Content: aContent}})
+			thenTheHistoryIsUnchaged(sub, subHistory)
+			thenThoseFilesExist(workdir, changeInSubmodule)
+		})
+
+		It("keeps changes inside submodules on revert by default", func() {
+			givenAFailingTestSetup(workdir, gitHelper)
+			givenASubmodule(workdir, gitHelper, submodule)
+			givenUnstangedChanges(workdir, changeInSubmodule)
+			givenAnyUnstagedChanges(workdir)
+
 			result := whenIRunTcr(binary, workdir)
 
 			thenTcrFails(result)
+			thenTheUnstagedChangesAreReset(workdir, test.Files{{Name: aFileName}})
+			thenThoseFilesExist(workdir, changeInSubmodule)
 		})
 
-		It("fails if no config is present", func() {
-			helper := test.NewGitHelper(workdir)
-			Expect(helper.WithCommits()).NotTo(HaveOccurred())
+		It("refuses to run with a dirty submodule", func() {
+			givenATestThatLogsRun(workdir, tempTestDir, gitHelper)
+			givenUnstangedChanges(workdir, test.Files{{Name: configFile, Content: `{"test": "./test.sh", "submodules": "refuse"}`}})
+			givenASubmodule(workdir, gitHelper, submodule)
+			givenUnstangedChanges(workdir, changeInSubmodule)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrExitsWith(result, 2)
+			thenItDisplays(result, "submodule lib has changes")
+			thenTestWasNotRun(tempTestDir)
+		})
+
+		It("commits submodules recursively", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "submodules": "recursive"}`)
+			sub := givenASubmodule(workdir, gitHelper, submodule)
+			subHistory := givenAGitHistory(sub)
+			givenUnstangedChanges(workdir, changeInSubmodule)
+			history := givenAGitHistory(gitHelper)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenANewCommitIsAdded(sub, subHistory, defaultCommitMessage)
+			thenANewCommitIsAdded(gitHelper, history, defaultCommitMessage)
+			thenTheSubmoduleIsRecorded(gitHelper, submodule, sub)
+			thenTheWorkingTreeIsClean(sub)
+		})
+
+		It("reverts submodules recursively", func() {
+			givenAFailingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "submodules": "recursive"}`)
+			sub := givenASubmodule(workdir, gitHelper, submodule)
+			subHistory := givenAGitHistory(sub)
+			givenUnstangedChanges(workdir, changeInSubmodule)
+
 			result := whenIRunTcr(binary, workdir)
 
 			thenTcrFails(result)
+			thenThoseFilesExist(workdir, test.Files{{Name: path.Join(submodule, aFileName), Content: aContent}})
+			thenTheHistoryIsUnchaged(sub, subHistory)
+		})
+	})
+
+	Context("linked worktrees", func() {
+		const branch = "experiment"
+
+		It("commits in a linked worktree", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			linked, linkedHelper := givenALinkedWorktree(gitHelper, branch)
+			history := givenAGitHistory(gitHelper)
+			linkedHistory := givenAGitHistory(linkedHelper)
+			givenAnyUnstagedChanges(linked)
+
+			result := whenIRunTcr(binary, linked)
+
+			thenTcrSucceeds(result)
+			thenANewCommitIsAdded(linkedHelper, linkedHistory, defaultCommitMessage)
+			thenTheWorkingTreeIsClean(linkedHelper)
+			thenTheHistoryIsUnchaged(gitHelper, history)
+		})
+
+		It("reverts in a linked worktree", func() {
+			givenAFailingTestSetup(workdir, gitHelper)
+			linked, linkedHelper := givenALinkedWorktree(gitHelper, branch)
+			linkedHistory := givenAGitHistory(linkedHelper)
+			givenUnstangedChanges(workdir, test.Files{{Name: "main", Content: aContent}})
+			givenAnyUnstagedChanges(linked)
+
+			result := whenIRunTcr(binary, linked)
+
+			thenTcrFails(result)
+			thenTheUnstagedChangesAreReset(linked, test.Files{{Name: aFileName}})
+			thenTheHistoryIsUnchaged(linkedHelper, linkedHistory)
+			thenThoseFilesExist(workdir, test.Files{{Name: "main", Content: aContent}})
+		})
+
+		It("keeps the state of every worktree separate", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "commit": {"message": "step {{.Step}}"}}`)
+			linked, linkedHelper := givenALinkedWorktree(gitHelper, branch)
+			givenAnyUnstagedChanges(workdir)
+			thenTcrSucceeds(whenIRunTcr(binary, workdir))
+			linkedHistory := givenAGitHistory(linkedHelper)
+			givenAnyUnstagedChanges(linked)
+
+			result := whenIRunTcr(binary, linked)
+
+			thenTcrSucceeds(result)
+			thenANewCommitIsAdded(linkedHelper, linkedHistory, "step 1")
+		})
+
+		It("keeps backups in the git directory of the worktree", func() {
+			givenAFailingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "revert": {"untracked": "backup"}}`)
+			linked, _ := givenALinkedWorktree(gitHelper, branch)
+			givenAnyUnstagedChanges(linked)
+
+			result := whenIRunTcr(binary, linked)
+
+			thenTcrFails(result)
+			thenABackupInTheGitDirContains(path.Join(workdir, ".git", "worktrees", path.Base(linked)), test.Files{{Name: aFileName, Content: aContent}})
 		})
 	})
 
-})
+	Context("lock", func() {
+		var release string
+
+		BeforeEach(func() {
+			release = path.Join(tempTestDir, "release")
+		})
+
+		It("exits while another cycle is running", func() {
+			givenATestSetupThatBlocksUntil(workdir, gitHelper, release, `{"test": "./test.sh"}`)
+			givenAnyUnstagedChanges(workdir)
+			running := whenIStartTcr(binary, workdir)
+			thenEventuallyTheLockIsHeld(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrExitsWith(result, 3)
+			thenItDisplays(result, "another tcr cycle is running")
+			whenIRelease(release)
+			Eventually(running, 10*time.Second).Should(gexec.Exit(0))
+			thenTheLockIsReleased(workdir)
+		})
+
+		It("waits for another cycle to finish", func() {
+			givenATestSetupThatBlocksUntil(workdir, gitHelper, release, `{"test": "./test.sh", "lock": {"wait": "10s"}}`)
+			givenAnyUnstagedChanges(workdir)
+			history := givenAGitHistory(gitHelper)
+			running := whenIStartTcr(binary, workdir)
+			thenEventuallyTheLockIsHeld(workdir)
+
+			waiting := whenIStartTcr(binary, workdir)
+			Consistently(waiting, 500*time.Millisecond).ShouldNot(gexec.Exit())
+			whenIRelease(release)
+
+			Eventually(running, 10*time.Second).Should(gexec.Exit(0))
+			Eventually(waiting, 10*time.Second).Should(gexec.Exit(0))
+			thenANewCommitIsAdded(gitHelper, history, defaultCommitMessage)
+			thenTheLockIsReleased(workdir)
+		})
+
+		It("takes over the lock of a crashed cycle", func() {
+			givenATestSetupThatBlocksUntil(workdir, gitHelper, release, `{"test": "./test.sh"}`)
+			givenAnyUnstagedChanges(workdir)
+			history := givenAGitHistory(gitHelper)
+			crashed := whenIStartTcr(binary, workdir)
+			thenEventuallyTheLockIsHeld(workdir)
+			Eventually(crashed.Kill(), 10*time.Second).Should(gexec.Exit())
+			whenIRelease(release)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenANewCommitIsAdded(gitHelper, history, defaultCommitMessage)
+			thenTheLockIsReleased(workdir)
+		})
+
+		It("takes over a lock file left behind", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			givenAStaleLock(workdir)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenTheWorkingTreeIsClean(gitHelper)
+			thenTheLockIsReleased(workdir)
+		})
+
+		It("lets only one of several cycles take over a lock file left behind", func() {
+			givenATestSetupThatBlocksUntil(workdir, gitHelper, release, `{"test": "./test.sh"}`)
+			givenAStaleLock(workdir)
+			givenAnyUnstagedChanges(workdir)
+			history := givenAGitHistory(gitHelper)
+
+			sessions := whenIStartSeveralTcrsAtOnce(binary, workdir, 16)
+
+			thenOnlyOneOfThemKeepsRunning(sessions)
+			whenIRelease(release)
+			for _, s := range sessions {
+				Eventually(s, 10*time.Second).Should(gexec.Exit())
+			}
+			thenANewCommitIsAdded(gitHelper, history, defaultCommitMessage)
+			thenTheLockIsReleased(workdir)
+		})
+	})
+
+	Context("notes", func() {
+		const notesConfig = `{"test": "./test.sh", "notes": {"enabled": true}}`
+
+		It("attaches a note describing the test run", func() {
+			givenAPassingTestSetupWithConfigAndOutput(workdir, gitHelper, notesConfig, "=== RUN TestA\\nok  example 0.1s\\n")
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenTheLastCommitHasANoteContaining(gitHelper, "command: ./test.sh", "duration: ", "exit code: 0", "tcr version: dev", "ok  example 0.1s")
+			thenTheLastCommitHasANoteNotContaining(gitHelper, "=== RUN")
+		})
+
+		It("summarizes the test output with a pattern", func() {
+			givenAPassingTestSetupWithConfigAndOutput(workdir, gitHelper,
+				`{"test": "./test.sh", "notes": {"enabled": true, "summary": "^--- PASS"}}`,
+				"--- PASS: TestA\\nother\\n--- PASS: TestB\\nok  example\\n")
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenTheLastCommitHasANoteContaining(gitHelper, "--- PASS: TestA\n--- PASS: TestB")
+			thenTheLastCommitHasANoteNotContaining(gitHelper, "ok  example")
+		})
+
+		It("keeps the notes of previous commits", func() {
+			givenAPassingTestSetupWithConfigAndOutput(workdir, gitHelper, notesConfig, "ok")
+			givenAnyUnstagedChanges(workdir)
+			thenTcrSucceeds(whenIRunTcr(binary, workdir))
+			givenUnstangedChanges(workdir, test.Files{{Name: aFileName, Content: anUpdatedContent}})
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			Expect(gitHelper.GitOutput("notes", "--ref", "tcr", "list")).To(HaveLen(2 * 82))
+		})
+
+		It("does not attach notes by default", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenTheReferenceDoesNotExist(gitHelper, "refs/notes/tcr")
+		})
+
+		It("shows commits with their notes", func() {
+			givenAPassingTestSetupWithConfigAndOutput(workdir, gitHelper, notesConfig, "ok  example")
+			givenAnyUnstagedChanges(workdir)
+			thenTcrSucceeds(whenIRunTcr(binary, workdir))
+
+			result := whenIRunTcr(binary, workdir, "log")
+
+			thenTcrSucceeds(result)
+			thenItDisplays(result, "    "+defaultCommitMessage)
+			thenItDisplays(result, "Notes (tcr):\n    command: ./test.sh")
+			thenItDisplays(result, "    ok  example")
+		})
+	})
+
+	Context("trailers", func() {
+		const trailersConfig = `{"test": "./test.sh", "profile": "kata", "commit": {"trailers": ["cycle", "duration", "command", "profile", "tests-passed"]}}`
+		const testOutput = "--- PASS: TestA\\n--- PASS: TestB\\nok  example\\n"
+
+		It("appends the configured trailers", func() {
+			givenAPassingTestSetupWithConfigAndOutput(workdir, gitHelper, trailersConfig, testOutput)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenTheLastCommitMessageContains(gitHelper,
+				defaultCommitMessage+"\n\nTcr-Cycle: 1\nTcr-Duration: ",
+				"\nTcr-Command: ./test.sh\nTcr-Profile: kata\nTcr-Tests-Passed: 2")
+		})
+
+		It("appends only the selected trailers", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "commit": {"trailers": ["cycle"]}}`)
+			givenAnyUnstagedChanges(workdir)
+			history := givenAGitHistory(gitHelper)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenANewCommitIsAdded(gitHelper, history, defaultCommitMessage+"\n\nTcr-Cycle: 1")
+		})
+
+		It("rejects unknown trailers", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "commit": {"trailers": ["unknown"]}}`)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenItDisplays(result, `unknown trailer \"unknown\"`)
+		})
+
+		It("shows the trailers of the history", func() {
+			givenAPassingTestSetupWithConfigAndOutput(workdir, gitHelper, trailersConfig, testOutput)
+			givenAnyUnstagedChanges(workdir)
+			thenTcrSucceeds(whenIRunTcr(binary, workdir))
+			givenUnstangedChanges(workdir, test.Files{{Name: aFileName, Content: anUpdatedContent}})
+			thenTcrSucceeds(whenIRunTcr(binary, workdir))
+
+			result := whenIRunTcr(binary, workdir, "log", "--trailers")
+
+			thenTcrSucceeds(result)
+			thenItDisplays(result, "commit   cycle  duration")
+			Expect(result.stdOut).To(MatchRegexp(`(?m)^[0-9a-f]{7}  2 +\S+ +2 +\./test\.sh +kata$`))
+			Expect(result.stdOut).To(MatchRegexp(`(?m)^[0-9a-f]{7}  1 +\S+ +2 +\./test\.sh +kata$`))
+		})
+
+		It("summarizes the cycles of the history", func() {
+			givenAPassingTestSetupWithConfigAndOutput(workdir, gitHelper, trailersConfig, testOutput)
+			givenAnyUnstagedChanges(workdir)
+			thenTcrSucceeds(whenIRunTcr(binary, workdir))
+			givenUnstangedChanges(workdir, test.Files{{Name: aFileName, Content: anUpdatedContent}})
+			thenTcrSucceeds(whenIRunTcr(binary, workdir))
+
+			result := whenIRunTcr(binary, workdir, "stats")
+
+			thenTcrSucceeds(result)
+			thenItDisplays(result, "cycles:         2")
+			thenItDisplays(result, "test duration:  ")
+			thenItDisplays(result, "tests passed:   2 in the last cycle")
+			thenItDisplays(result, "profiles:       kata (2)")
+		})
+	})
+
+	Context("auto push", func() {
+		It("pushes the commit to the branch of the same name", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "autoPush": {}}`)
+			remote := givenARemote(gitHelper, "origin")
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenTheRemoteBranchIsAtHead(remote, "master", gitHelper)
+		})
+
+		It("pushes to the configured remote and branch", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "autoPush": {"remote": "upstream", "branch": "tcr"}}`)
+			remote := givenARemote(gitHelper, "upstream")
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenTheRemoteBranchIsAtHead(remote, "tcr", gitHelper)
+			thenTheReferenceDoesNotExist(remote, "refs/heads/master")
+		})
+
+		It("keeps the commit if pushing fails", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "autoPush": {}}`)
+			Expect(gitHelper.Git("remote", "add", "origin", path.Join(workdir, "missing"))).NotTo(HaveOccurred())
+			givenAnyUnstagedChanges(workdir)
+			history := givenAGitHistory(gitHelper)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrExitsWith(result, 4)
+			thenItDisplays(result, "error on pushing, the commit is kept locally")
+			thenANewCommitIsAdded(gitHelper, history, defaultCommitMessage)
+			thenTheWorkingTreeIsClean(gitHelper)
+		})
+
+		It("does not push if the tests fail", func() {
+			givenAFailingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "autoPush": {}}`)
+			remote := givenARemote(gitHelper, "origin")
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenTheReferenceDoesNotExist(remote, "refs/heads/master")
+		})
+
+		It("is not supported with shadow commits", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "autoPush": {}, "commit": {"shadow": true}}`)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenItDisplays(result, "autoPush is not supported with commit.shadow")
+		})
+	})
+
+	Context("hooks", func() {
+		const hooksConfig = `{"test": "./test.sh", "commit": {"hooks": true}}`
+
+		It("keeps the changes if the pre-commit hook rejects the commit", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, hooksConfig)
+			givenAHook(path.Join(workdir, ".git", "hooks"), "pre-commit", "echo 'formatting is off'\nexit 1")
+			givenAnyUnstagedChanges(workdir)
+			history := givenAGitHistory(gitHelper)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrExitsWith(result, 5)
+			thenItDisplays(result, "formatting is off")
+			thenItDisplays(result, "pre-commit hook rejected the commit")
+			thenTheHistoryIsUnchaged(gitHelper, history)
+			thenThoseFilesExist(workdir, test.Files{{Name: aFileName, Content: aContent}})
+		})
+
+		It("runs the pre-commit hook with the changes staged", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, hooksConfig)
+			givenAHook(path.Join(workdir, ".git", "hooks"), "pre-commit", "git diff --cached --quiet && exit 1\nexit 0")
+			givenAnyUnstagedChanges(workdir)
+			history := givenAGitHistory(gitHelper)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenANewCommitIsAdded(gitHelper, history, defaultCommitMessage)
+		})
+
+		It("commits the message edited by the commit-msg hook", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, hooksConfig)
+			givenAHook(path.Join(workdir, ".git", "hooks"), "prepare-commit-msg", `[ "$2" = message ] || exit 1`)
+			givenAHook(path.Join(workdir, ".git", "hooks"), "commit-msg", `printf '\nRefs: TCR-1\n' >> "$1"`)
+			givenAnyUnstagedChanges(workdir)
+			history := givenAGitHistory(gitHelper)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenANewCommitIsAdded(gitHelper, history, defaultCommitMessage+"\n\nRefs: TCR-1")
+		})
+
+		It("runs the hooks of core.hooksPath", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, hooksConfig)
+			givenAGitConfig(gitHelper, "core", "hooksPath", ".githooks")
+			marker := path.Join(home, "post-commit")
+			givenAHook(path.Join(workdir, ".githooks"), "post-commit", "touch '"+marker+"'")
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			Expect(marker).To(BeAnExistingFile())
+		})
+
+		It("does not run hooks by default", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			givenAHook(path.Join(workdir, ".git", "hooks"), "pre-commit", "exit 1")
+			givenAnyUnstagedChanges(workdir)
+			history := givenAGitHistory(gitHelper)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenANewCommitIsAdded(gitHelper, history, defaultCommitMessage)
+		})
+	})
+
+	Context("file replaced by a directory", func() {
+		replaced := test.Files{{Name: "conf/a", Content: aContent}}
+
+		It("commits the files of the directory", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			givenACommit(workdir, gitHelper, test.Files{{Name: "conf", Content: aContent}})
+			givenDeletedFiles(workdir, "conf")
+			givenUnstangedChanges(workdir, replaced)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenTheCommitContains(gitHelper, replaced)
+			thenTheWorkingTreeIsClean(gitHelper)
+		})
+
+		It("restores the file", func() {
+			givenAFailingTestSetup(workdir, gitHelper)
+			givenACommit(workdir, gitHelper, test.Files{{Name: "conf", Content: aContent}})
+			givenDeletedFiles(workdir, "conf")
+			givenUnstangedChanges(workdir, replaced)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenThoseFilesExist(workdir, test.Files{{Name: "conf", Content: aContent}})
+			thenTheWorkingTreeIsClean(gitHelper)
+		})
+
+		It("tests the files of the directory in an isolated checkout", func() {
+			givenATestThatRuns(workdir, gitHelper, `{"test": "./test.sh", "isolate": true}`, "test -f conf/a", 0)
+			givenACommit(workdir, gitHelper, test.Files{{Name: "conf", Content: aContent}})
+			givenDeletedFiles(workdir, "conf")
+			givenUnstangedChanges(workdir, replaced)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenTheCommitContains(gitHelper, replaced)
+		})
+	})
+
+	Context("scope", func() {
+		inside := test.Files{{Name: "billing/invoice", Content: aContent}}
+		outside := test.Files{{Name: "shipping/parcel", Content: aContent}}
+
+		It("commits only the changes inside the scope", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			givenUnstangedChanges(workdir, append(inside, outside...))
+			history := givenAGitHistory(gitHelper)
+
+			result := whenIRunTcr(binary, workdir, "--scope", "billing")
+
+			thenTcrSucceeds(result)
+			thenANewCommitIsAdded(gitHelper, history, defaultCommitMessage)
+			thenTheCommitContains(gitHelper, inside)
+			thenTheCommitDoesNotContain(gitHelper, outside[0].Name)
+			thenThoseFilesExist(workdir, outside)
+		})
+
+		It("reverts only the changes inside the scope", func() {
+			givenAFailingTestSetup(workdir, gitHelper)
+			givenUnstangedChanges(workdir, append(inside, outside...))
+
+			result := whenIRunTcr(binary, workdir, "--scope", "billing/")
+
+			thenTcrFails(result)
+			Expect(path.Join(workdir, inside[0].Name)).NotTo(BeAnExistingFile())
+			thenThoseFilesExist(workdir, outside)
+		})
+
+		It("leaves the index to git with the git vcs", func() {
+			if backend != "git" {
+				Skip("go-git writes the index itself")
+			}
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "scope": "billing"}`)
+			givenACommit(workdir, gitHelper, outside)
+			givenStagedChanges(workdir, gitHelper, test.Files{{Name: outside[0].Name, Content: anUpdatedContent}})
+			givenTheUntrackedCacheIsEnabled(gitHelper)
+			givenUnstangedChanges(workdir, inside)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenOnlyThoseFilesAreStaged(gitHelper, outside[0].Name)
+			thenTheIndexHasTheUntrackedCache(workdir)
+		})
+
+		It("does not commit changes staged outside the scope and keeps them staged", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "scope": "billing"}`)
+			givenACommit(workdir, gitHelper, outside)
+			givenStagedChanges(workdir, gitHelper, test.Files{{Name: outside[0].Name, Content: anUpdatedContent}})
+			givenUnstangedChanges(workdir, inside)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenTheCommitContains(gitHelper, append(inside, outside...))
+			thenThoseFilesExist(workdir, test.Files{{Name: outside[0].Name, Content: anUpdatedContent}})
+			thenOnlyThoseFilesAreStaged(gitHelper, outside[0].Name)
+		})
+
+		It("does nothing if there are no changes inside the scope", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			givenUnstangedChanges(workdir, outside)
+			history := givenAGitHistory(gitHelper)
+
+			result := whenIRunTcr(binary, workdir, "--scope", "billing")
+
+			thenTcrSucceeds(result)
+			thenItDisplays(result, "worktree is clean, nothing to do")
+			thenTheHistoryIsUnchaged(gitHelper, history)
+		})
+
+		It("refuses a scope outside the worktree", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir, "--scope", "../billing")
+
+			thenTcrFails(result)
+			thenItDisplays(result, "must be a directory inside the worktree")
+		})
+	})
+
+	Context("last green commit", func() {
+		const greenConfig = `{"test": "./test.sh", "revert": {"target": "green"}}`
+		failingTest := test.Files{{Name: "test.sh", Content: "#!/usr/bin/env bash\nexit 1"}}
+
+		It("points to the last commit that passed the tests", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			head, err := gitHelper.Head()
+			Expect(err).NotTo(HaveOccurred())
+			thenTheLastGreenCommitIs(gitHelper, head)
+		})
+
+		It("reverts to the last green commit", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, greenConfig)
+			givenAnyUnstagedChanges(workdir)
+			thenTcrSucceeds(whenIRunTcr(binary, workdir))
+			green, err := gitHelper.Head()
+			Expect(err).NotTo(HaveOccurred())
+			givenACommit(workdir, gitHelper, failingTest)
+			unverified, err := gitHelper.Head()
+			Expect(err).NotTo(HaveOccurred())
+			givenUnstangedChanges(workdir, test.Files{{Name: aFileName, Content: anUpdatedContent}})
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			Expect(gitHelper.Head()).To(Equal(green))
+			Expect(gitHelper.GitOutput("rev-parse", "ORIG_HEAD")).To(HavePrefix(unverified))
+			thenThoseFilesExist(workdir, test.Files{{Name: aFileName, Content: aContent}})
+			thenTheWorkingTreeIsClean(gitHelper)
+		})
+
+		It("refuses to revert to the last green commit within a scope", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, greenConfig)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir, "--scope", "billing")
+
+			thenTcrExitsWith(result, 1)
+			thenItDisplays(result, "revert.target green is not supported with a scope")
+			thenThoseFilesExist(workdir, test.Files{{Name: aFileName, Content: aContent}})
+		})
+
+		It("records the last green commit on a detached HEAD", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, greenConfig)
+			givenAnyUnstagedChanges(workdir)
+			thenTcrSucceeds(whenIRunTcr(binary, workdir))
+			Expect(gitHelper.Git("checkout", "-q", "--detach")).To(Succeed())
+			givenUnstangedChanges(workdir, test.Files{{Name: aFileName, Content: anUpdatedContent}})
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			green, err := gitHelper.Head()
+			Expect(err).NotTo(HaveOccurred())
+			thenTheLastGreenCommitIs(gitHelper, green)
+		})
+
+		It("reverts to HEAD by default", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			givenAnyUnstagedChanges(workdir)
+			thenTcrSucceeds(whenIRunTcr(binary, workdir))
+			givenACommit(workdir, gitHelper, failingTest)
+			history := givenAGitHistory(gitHelper)
+			givenUnstangedChanges(workdir, test.Files{{Name: aFileName, Content: anUpdatedContent}})
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenTheHistoryIsUnchaged(gitHelper, history)
+			thenThoseFilesExist(workdir, failingTest)
+		})
+
+		It("warns if HEAD is ahead of the last green commit", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			givenAnyUnstagedChanges(workdir)
+			thenTcrSucceeds(whenIRunTcr(binary, workdir))
+			givenACommit(workdir, gitHelper, failingTest)
+
+			result := whenIRunTcr(binary, workdir, "status")
+
+			thenTcrSucceeds(result)
+			thenItDisplays(result, "last green:  ")
+			thenItDisplays(result, "HEAD is 1 commit(s) ahead of the last green commit")
+		})
+
+		It("shows the last green commit", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			givenAnyUnstagedChanges(workdir)
+			thenTcrSucceeds(whenIRunTcr(binary, workdir))
+
+			result := whenIRunTcr(binary, workdir, "status")
+
+			thenTcrSucceeds(result)
+			thenItDisplays(result, "last green:  ")
+			thenItDisplays(result, defaultCommitMessage)
+			thenItDoesNotDisplay(result, "ahead")
+		})
+	})
+
+	Context("changes while the tests are running", func() {
+		const editWhileTesting = "printf 'edited' > '" + aFileName + "'\nprintf 'late' > late"
+		edited := test.Files{{Name: aFileName, Content: "edited"}, {Name: "late", Content: "late"}}
+
+		It("commits the tested state and keeps the changes", func() {
+			givenATestThatRuns(workdir, gitHelper, `{"test": "./test.sh"}`, editWhileTesting, 0)
+			givenAnyUnstagedChanges(workdir)
+			history := givenAGitHistory(gitHelper)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenItDisplays(result, "files have been changed while the tests were running: late, new")
+			thenANewCommitIsAdded(gitHelper, history, defaultCommitMessage)
+			thenTheCommitContains(gitHelper, test.Files{{Name: aFileName, Content: aContent}})
+			thenTheCommitDoesNotContain(gitHelper, "late")
+			thenThoseFilesExist(workdir, edited)
+			thenTheWorkingTreeIsNotClean(gitHelper)
+		})
+
+		It("backs up the changes before reverting", func() {
+			givenATestThatRuns(workdir, gitHelper, `{"test": "./test.sh"}`, editWhileTesting, 1)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenABackupContains(workdir, edited)
+			thenTheWorkingTreeIsClean(gitHelper)
+		})
+
+		It("aborts the cycle if HEAD moves", func() {
+			givenATestThatRuns(workdir, gitHelper, `{"test": "./test.sh"}`, "git commit -q --allow-empty -m moved", 0)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenItDisplays(result, "HEAD has moved while the tests were running")
+			thenTheLastCommitMessageContains(gitHelper, "moved")
+			thenThoseFilesExist(workdir, test.Files{{Name: aFileName, Content: aContent}})
+		})
+	})
+
+	Context("isolation", func() {
+		const isolateConfig = `{"test": "./test.sh", "isolate": true}`
+
+		It("runs the tests on the tracked files and the pending changes only", func() {
+			givenATestThatRuns(workdir, gitHelper, isolateConfig, "[ -f tcr.json ] && [ -f new ] && [ ! -e gone ] && [ ! -e build ] || exit 1", 0)
+			givenACommit(workdir, gitHelper, test.Files{{Name: ".gitignore", Content: "build/\n"}, {Name: "gone", Content: aContent}})
+			givenDeletedFiles(workdir, "gone")
+			givenUnstangedChanges(workdir, test.Files{{Name: "build/artefact", Content: aContent}})
+			givenAnyUnstagedChanges(workdir)
+			history := givenAGitHistory(gitHelper)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenANewCommitIsAdded(gitHelper, history, defaultCommitMessage)
+			thenTheCommitDoesNotContain(gitHelper, "gone")
+			thenThoseFilesExist(workdir, test.Files{{Name: "build/artefact", Content: aContent}})
+		})
+
+		It("reverts the worktree if the tests fail", func() {
+			givenATestThatRuns(workdir, gitHelper, isolateConfig, "", 1)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenTheWorkingTreeIsClean(gitHelper)
+		})
+
+		It("removes the checkout after the test run", func() {
+			checkout := path.Join(home, "checkout")
+			givenATestThatRuns(workdir, gitHelper, isolateConfig, "pwd > '"+checkout+"'", 0)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			dir, err := os.ReadFile(checkout)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(strings.TrimSpace(string(dir))).NotTo(Equal(workdir))
+			Expect(strings.TrimSpace(string(dir))).NotTo(BeADirectory())
+		})
+
+		It("removes a checkout left behind by a previous cycle", func() {
+			givenATestThatRuns(workdir, gitHelper, isolateConfig, "", 0)
+			stale := path.Join(home, "stale-checkout")
+			givenACheckoutLeftBehind(workdir, stale)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			Expect(stale).NotTo(BeADirectory())
+		})
+	})
+
+	Context("timeout", func() {
+		const hangingTest = "sleep 10"
+
+		It("stops hanging tests and reverts", func() {
+			givenATestThatRuns(workdir, gitHelper, `{"test": "./test.sh", "timeout": {"after": "200ms", "grace": "200ms"}}`, hangingTest, 0)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenItDisplays(result, "tests have not finished within 200ms")
+			thenTheWorkingTreeIsClean(gitHelper)
+		})
+
+		It("keeps the changes if a timeout is configured as error", func() {
+			givenATestThatRuns(workdir, gitHelper, `{"test": "./test.sh", "timeout": {"after": "200ms", "grace": "200ms", "outcome": "error"}}`, hangingTest, 0)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenItDisplays(result, "tests have timed out")
+			thenThoseFilesExist(workdir, test.Files{{Name: aFileName, Content: aContent}})
+		})
+
+		It("commits passing tests that leave a process holding their output behind", func() {
+			givenATestThatRuns(workdir, gitHelper, `{"test": "./test.sh", "timeout": {"grace": "200ms"}}`, "(sleep 3) &", 0)
+			givenAnyUnstagedChanges(workdir)
+			history := givenAGitHistory(gitHelper)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenANewCommitIsAdded(gitHelper, history, defaultCommitMessage)
+		})
+
+		It("stops the processes spawned by the tests", func() {
+			marker := path.Join(home, "child")
+			stubborn := path.Join(home, "stubborn")
+			givenATestThatRuns(workdir, gitHelper, `{"test": "./test.sh", "timeout": {"after": "200ms", "grace": "200ms"}}`,
+				"(sleep 0.5; touch '"+marker+"') &\n"+
+					"(trap '' TERM; sleep 0.8; touch '"+stubborn+"') >/dev/null 2>&1 &\n"+
+					hangingTest, 0)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			Consistently(marker, "1s").ShouldNot(BeAnExistingFile())
+			Expect(stubborn).NotTo(BeAnExistingFile())
+		})
+
+		It("kills tests ignoring the termination after the grace period", func() {
+			givenATestThatRuns(workdir, gitHelper, `{"test": "./test.sh", "timeout": {"after": "200ms", "grace": "200ms"}}`, "trap '' TERM\n"+hangingTest, 0)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenTheWorkingTreeIsClean(gitHelper)
+		})
+	})
+
+	Context("interrupts", func() {
+		var started string
+		var forwarded string
+
+		BeforeEach(func() {
+			started = path.Join(home, "started")
+			forwarded = path.Join(home, "forwarded")
+		})
+
+		It("neither commits nor reverts if interrupted", func() {
+			givenATestThatWaitsForSignals(workdir, gitHelper, `{"test": "./test.sh"}`, started, "trap \"touch '"+forwarded+"'; exit 1\" INT")
+			givenAnyUnstagedChanges(workdir)
+			history := givenAGitHistory(gitHelper)
+			session := whenIStartTcr(binary, workdir)
+
+			whenISignalTcrOnceTheTestsRun(session, started, os.Interrupt)
+
+			Eventually(session, 5*time.Second).Should(gexec.Exit(130))
+			Expect(forwarded).To(BeAnExistingFile())
+			thenTheHistoryIsUnchaged(gitHelper, history)
+			thenThoseFilesExist(workdir, test.Files{{Name: aFileName, Content: aContent}})
+			thenTheLockIsReleased(workdir)
+		})
+
+		It("does not commit if the tests pass after being terminated", func() {
+			givenATestThatWaitsForSignals(workdir, gitHelper, `{"test": "./test.sh"}`, started, "trap \"touch '"+forwarded+"'; exit 0\" TERM")
+			givenAnyUnstagedChanges(workdir)
+			history := givenAGitHistory(gitHelper)
+			session := whenIStartTcr(binary, workdir)
+
+			whenISignalTcrOnceTheTestsRun(session, started, syscall.SIGTERM)
+
+			Eventually(session, 5*time.Second).Should(gexec.Exit(130))
+			Expect(forwarded).To(BeAnExistingFile())
+			thenTheHistoryIsUnchaged(gitHelper, history)
+			thenTheWorkingTreeIsNotClean(gitHelper)
+		})
+
+		It("kills tests ignoring the interrupt after the grace period", func() {
+			givenATestThatWaitsForSignals(workdir, gitHelper, `{"test": "./test.sh", "timeout": {"grace": "200ms"}}`, started, "trap '' INT")
+			givenAnyUnstagedChanges(workdir)
+			session := whenIStartTcr(binary, workdir)
+
+			whenISignalTcrOnceTheTestsRun(session, started, os.Interrupt)
+
+			Eventually(session, 5*time.Second).Should(gexec.Exit(130))
+			thenThoseFilesExist(workdir, test.Files{{Name: aFileName, Content: aContent}})
+			thenTheLockIsReleased(workdir)
+		})
+	})
+
+	Context("test execution fails", func() {
+		It("does not revert", func() {
+			givenATestSetupWithNonExecutableTests(workdir, gitHelper)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenTheWorkingTreeIsNotClean(gitHelper)
+		})
+	})
+
+	Context("test commands", func() {
+		It("supports arguments", func() {
+			givenATestCommandThatNeedsArguments(gitHelper, workdir)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+		})
+	})
+
+	Context("commit message", func() {
+		It("renders the configured template", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, `{
+				"test": "./test.sh",
+				"commit": {"message": "{{.Ticket}} step {{.Step}} on {{.Branch}}: {{join .Files \", \"}}"}
+			}`)
+			givenABranch(gitHelper, "feature/TCR-42-parser")
+			history := givenAGitHistory(gitHelper)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenANewCommitIsAdded(gitHelper, history, "TCR-42 step 1 on feature/TCR-42-parser: new")
+		})
+
+		It("counts steps across runs", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "commit": {"message": "step {{.Step}}"}}`)
+			givenAnyUnstagedChanges(workdir)
+			thenTcrSucceeds(whenIRunTcr(binary, workdir))
+			history := givenAGitHistory(gitHelper)
+			givenUnstangedChanges(workdir, test.Files{{Name: aFileName, Content: anUpdatedContent}})
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenANewCommitIsAdded(gitHelper, history, "step 2")
+		})
+
+		It("uses a message given on the command line", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			history := givenAGitHistory(gitHelper)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir, "-m", "extract parser")
+
+			thenTcrSucceeds(result)
+			thenANewCommitIsAdded(gitHelper, history, "extract parser")
+		})
+
+		It("accepts conventional commits", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, `{"test": "./test.sh", "commit": {"conventional": {"scopes": ["parser"]}}}`)
+			history := givenAGitHistory(gitHelper)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir, "-m", "refactor(parser): extract tokenizer")
+
+			thenTcrSucceeds(result)
+			thenANewCommitIsAdded(gitHelper, history, "refactor(parser): extract tokenizer")
+		})
+
+		It("fails before testing if the message is no conventional commit", func() {
+			givenATestThatRuns(workdir, gitHelper, `{"test": "./test.sh", "commit": {"conventional": {"scopes": ["parser"]}}}`, "touch '"+path.Join(tempTestDir, "ran")+"'", 0)
+			history := givenAGitHistory(gitHelper)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir, "-m", "refactor(lexer): extract tokenizer")
+
+			thenTcrFails(result)
+			thenItDisplays(result, "is not one of parser")
+			thenTestWasNotRun(tempTestDir)
+			thenTheWorkingTreeIsNotClean(gitHelper)
+			thenTheHistoryIsUnchaged(gitHelper, history)
+		})
+
+		It("fails before testing if the template is invalid", func() {
+			givenATestThatRuns(workdir, gitHelper, `{"test": "./test.sh", "commit": {"message": "step {{.Step"}}`, "touch '"+path.Join(tempTestDir, "ran")+"'", 0)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenItDisplays(result, "invalid commit message template")
+			thenTestWasNotRun(tempTestDir)
+		})
+	})
+
+	Context("timer", func() {
+		It("reverts uncommitted changes when time is up", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			history := givenAGitHistory(gitHelper)
+			givenAnyUnstagedChanges(workdir)
+
+			session := whenIStartTcr(binary, workdir, "timer", "--minutes", "0.02")
+			defer session.Kill()
+
+			thenEventuallyTheUnstagedChangesAreReset(workdir, test.Files{{Name: aFileName, Content: aContent}})
+			thenABackupContains(workdir, test.Files{{Name: aFileName, Content: aContent}})
+			thenTheHistoryIsUnchaged(gitHelper, history)
+		})
+
+		It("restarts the countdown on a green cycle", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			session := whenIStartTcr(binary, workdir, "timer", "--minutes", "1")
+			defer session.Kill()
+			Eventually(session).Should(gbytes.Say("timer started"))
+			givenAnyUnstagedChanges(workdir)
+
+			thenTcrSucceeds(whenIRunTcr(binary, workdir))
+
+			Eventually(session).WithTimeout(5 * time.Second).Should(gbytes.Say("green cycle, timer restarted"))
+		})
+
+		It("does not restart the countdown on a commit made by hand", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			session := whenIStartTcr(binary, workdir, "timer", "--minutes", "1")
+			defer session.Kill()
+			Eventually(session).Should(gbytes.Say("timer started"))
+
+			givenACommit(workdir, gitHelper, test.Files{{Name: aFileName, Content: aContent}})
+
+			Consistently(session, 2*time.Second).ShouldNot(gbytes.Say("timer restarted"))
+		})
+
+		It("stops on interrupt", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+
+			session := whenIStartTcr(binary, workdir, "timer", "--minutes", "1")
+			Eventually(session).Should(gbytes.Say("timer started"))
+			session.Interrupt()
+
+			Eventually(session).WithTimeout(5 * time.Second).Should(gexec.Exit(0))
+		})
+	})
+
+	Context("mob", func() {
+		const alice = "Alice <alice@example.com>"
+		const bob = "Bob <bob@example.com>"
+
+		It("authors commits as the driver and credits the mob", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			history := givenAGitHistory(gitHelper)
+			whenIStartAMob(binary, workdir, alice, bob)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenANewCommitIsAdded(gitHelper, history, defaultCommitMessage+"\n\nCo-authored-by: "+alice+"\nCo-authored-by: "+bob)
+			thenTheLastCommitIsAuthoredBy(gitHelper, alice)
+		})
+
+		It("resolves configured members", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, `{
+				"test": "./test.sh",
+				"mob": {"members": {"alice": "Alice <alice@example.com>", "bob": "Bob <bob@example.com>"}}
+			}`)
+			history := givenAGitHistory(gitHelper)
+			whenIStartAMob(binary, workdir, "alice", "bob")
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenANewCommitIsAdded(gitHelper, history, defaultCommitMessage+"\n\nCo-authored-by: "+alice+"\nCo-authored-by: "+bob)
+			thenTheLastCommitIsAuthoredBy(gitHelper, alice)
+		})
+
+		It("resolves members from the authors of the history", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			Expect(gitHelper.Git("commit", "-q", "--allow-empty", "-m", "by alice", "--author", alice)).To(Succeed())
+			Expect(gitHelper.Git("commit", "-q", "--allow-empty", "-m", "by bob", "--author", bob)).To(Succeed())
+			history := givenAGitHistory(gitHelper)
+			whenIStartAMob(binary, workdir, "alice", "bob")
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenANewCommitIsAdded(gitHelper, history, defaultCommitMessage+"\n\nCo-authored-by: "+alice+"\nCo-authored-by: "+bob)
+			thenTheLastCommitIsAuthoredBy(gitHelper, alice)
+		})
+
+		It("rotates the driver", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+
+			session := whenIStartTcr(binary, workdir, "mob", "start", "--minutes", "0.02", alice, bob)
+			defer session.Kill()
+
+			Eventually(session).WithTimeout(5 * time.Second).Should(gbytes.Say("Bob <bob@example.com> drives"))
+		})
+
+		It("refuses unknown members", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+
+			result := whenIRunTcr(binary, workdir, "mob", "start", "alice")
+
+			thenTcrFails(result)
+		})
+
+		It("uses the default identity after the mob stopped", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			history := givenAGitHistory(gitHelper)
+			whenIStartAMob(binary, workdir, alice, bob)
+			thenTcrSucceeds(whenIRunTcr(binary, workdir, "mob", "stop"))
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenANewCommitIsAdded(gitHelper, history, defaultCommitMessage)
+			thenTheLastCommitIsAuthoredBy(gitHelper, test.AuthorName+" <"+test.AuthorEmail+">")
+		})
+	})
+
+	Context("shadow branch", func() {
+		const shadowConfig = `{"test": "./test.sh", "commit": {"shadow": true}}`
+		const shadowRef = "refs/tcr-shadow/master"
+
+		It("commits to the shadow branch without moving the branch", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, shadowConfig)
+			history := givenAGitHistory(gitHelper)
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenTheHistoryIsUnchaged(gitHelper, history)
+			thenTheReferenceHasMessage(gitHelper, shadowRef, defaultCommitMessage)
+			thenThoseFilesExist(workdir, test.Files{{Name: aFileName, Content: aContent}})
+		})
+
+		It("considers committed shadow changes as clean", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, shadowConfig)
+			givenAnyUnstagedChanges(workdir)
+			thenTcrSucceeds(whenIRunTcr(binary, workdir))
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenItDisplays(result, "worktree is clean")
+		})
+
+		It("reverts to the last shadow commit", func() {
+			givenAFailingTestSetup(workdir, gitHelper)
+			givenACommit(workdir, gitHelper, test.Files{{Name: configFile, Content: shadowConfig}, {Name: "test.sh", Content: "#!/usr/bin/env bash\nexit 0"}})
+			givenAnyUnstagedChanges(workdir)
+			thenTcrSucceeds(whenIRunTcr(binary, workdir))
+			givenUnstangedChanges(workdir, test.Files{
+				{Name: aFileName, Content: anUpdatedContent},
+				{Name: "test.sh", Content: "#!/usr/bin/env bash\nexit 1"},
+			})
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenThoseFilesExist(workdir, test.Files{
+				{Name: aFileName, Content: aContent},
+				{Name: "test.sh", Content: "#!/usr/bin/env bash\nexit 0"},
+			})
+		})
+
+		It("keeps the shadow history apart from the last green commit", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			givenAnyUnstagedChanges(workdir)
+			thenTcrSucceeds(whenIRunTcr(binary, workdir))
+			givenABranch(gitHelper, "green")
+			givenACommit(workdir, gitHelper, test.Files{{Name: configFile, Content: shadowConfig}, {Name: "work.txt", Content: "work"}})
+			givenUnstangedChanges(workdir, test.Files{{Name: "test.sh", Content: "#!/usr/bin/env bash\nexit 1"}})
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+			thenThoseFilesExist(workdir, test.Files{{Name: "work.txt", Content: "work"}})
+			thenTheWorkingTreeIsClean(gitHelper)
+		})
+
+		It("commits to the shadow branch of a branch below green", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			givenAnyUnstagedChanges(workdir)
+			thenTcrSucceeds(whenIRunTcr(binary, workdir))
+			givenABranch(gitHelper, "green/feature")
+			givenACommit(workdir, gitHelper, test.Files{{Name: configFile, Content: shadowConfig}})
+			givenUnstangedChanges(workdir, test.Files{{Name: aFileName, Content: anUpdatedContent}})
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenTheReferenceHasMessage(gitHelper, "refs/tcr-shadow/green/feature", defaultCommitMessage)
+		})
+
+		It("publishes by fast-forwarding", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, shadowConfig)
+			history := givenAGitHistory(gitHelper)
+			givenAnyUnstagedChanges(workdir)
+			thenTcrSucceeds(whenIRunTcr(binary, workdir))
+
+			result := whenIRunTcr(binary, workdir, "publish")
+
+			thenTcrSucceeds(result)
+			thenANewCommitIsAdded(gitHelper, history, defaultCommitMessage)
+			thenTheWorkingTreeIsClean(gitHelper)
+			thenTheReferenceDoesNotExist(gitHelper, shadowRef)
+		})
+
+		It("publishes by squashing", func() {
+			givenAPassingTestSetupWithConfig(workdir, gitHelper, shadowConfig)
+			history := givenAGitHistory(gitHelper)
+			givenAnyUnstagedChanges(workdir)
+			thenTcrSucceeds(whenIRunTcr(binary, workdir))
+			givenUnstangedChanges(workdir, test.Files{{Name: aFileName, Content: anUpdatedContent}})
+			thenTcrSucceeds(whenIRunTcr(binary, workdir))
+
+			result := whenIRunTcr(binary, workdir, "publish", "--squash", "-m", "extract parser")
+
+			thenTcrSucceeds(result)
+			thenANewCommitIsAdded(gitHelper, history, "extract parser")
+			thenTheWorkingTreeIsClean(gitHelper)
+			thenThoseFilesExist(workdir, test.Files{{Name: aFileName, Content: anUpdatedContent}})
+		})
+	})
+
+	Context("test output", func() {
+		It("is swallowed if test passes", func() {
+			givenAPassingTestSetupWithOutput(workdir, gitHelper, "some random output")
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenItDoesNotDisplay(result, "some random output")
+		})
+
+		It("is printed if test fails", func() {
+			givenAFailingTestSetupWithOutput(workdir, gitHelper, "some random output")
+			givenAnyUnstagedChanges(workdir)
+
+			result := whenIRunTcr(binary, workdir)
+
+			thenItDisplays(result, "some random output")
+		})
+	})
+
+	Context("clean worktree", func() {
+		It("does not create a new commit", func() {
+			givenAPassingTestSetup(workdir, "", gitHelper)
+			givenACommit(workdir, gitHelper, test.Files{{Name: aFileName, Content: aContent}})
+			commits := givenAGitHistory(gitHelper)
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenTheWorkingTreeIsClean(gitHelper)
+			thenTheHistoryIsUnchaged(gitHelper, commits)
+		})
+
+		It("does not test", func() {
+			givenATestThatLogsRun(workdir, tempTestDir, gitHelper)
+			givenACommit(workdir, gitHelper, test.Files{{Name: aFileName, Content: aContent}})
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrSucceeds(result)
+			thenTheWorkingTreeIsClean(gitHelper)
+			thenTestWasNotRun(tempTestDir)
+		})
+	})
+
+	Context("error cases", func() {
+		It("fails if not run within a git repository", func() {
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+		})
+
+		It("fails if no config is present", func() {
+			helper := test.NewGitHelper(workdir)
+			Expect(helper.WithCommits()).NotTo(HaveOccurred())
+			result := whenIRunTcr(binary, workdir)
+
+			thenTcrFails(result)
+		})
+	})
+
+},
+	Entry("with go-git", "go-git"),
+	Entry("with git", "git"),
+)